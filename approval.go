@@ -0,0 +1,398 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	proposalsDir     = "_proposals"
+	rejectedAuditDir = "_audit/rejected"
+)
+
+// FlagApproval помечает поля с тегом approval:"required", изменения которых применяются
+// только после одобрения другим principal
+const FlagApproval = "approval"
+
+var (
+	ErrApprovalRequired = errors.New("change requires approval")
+	ErrProposalNotFound = errors.New("proposal not found")
+	ErrSelfApproval     = errors.New("proposal cannot be approved by its author")
+	ErrProposalStale    = errors.New("config changed since the proposal was made")
+)
+
+// PendingApprovalError возвращается из Set и SetMany, когда изменение затрагивает поля
+// с approval:"required": вместо записи создано предложение ProposalID
+type PendingApprovalError struct {
+	ProposalID string
+}
+
+func (e *PendingApprovalError) Error() string {
+	return fmt.Sprintf("%v: proposal %s is pending", ErrApprovalRequired, e.ProposalID)
+}
+
+func (e *PendingApprovalError) Unwrap() error {
+	return ErrApprovalRequired
+}
+
+// Proposal предложение изменить одно или несколько полей. Значения секретов скрыты.
+type Proposal struct {
+	ID        string             `json:"id"`
+	Values    map[ConfigName]any `json:"values"`
+	Proposer  Principal          `json:"proposer"`
+	Meta      ChangeMeta         `json:"meta"`
+	CreatedAt time.Time          `json:"created_at"`
+	ExpiresAt time.Time          `json:"expires_at"`
+}
+
+// RejectedProposal запись аудита об отклонённом предложении
+type RejectedProposal struct {
+	Proposal
+	RejectedBy Principal `json:"rejected_by"`
+	Reason     string    `json:"reason,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+type proposalRecord struct {
+	Values    map[ConfigName]json.RawMessage `json:"values"`
+	Revisions map[ConfigName]int64           `json:"revisions"`
+	Proposer  Principal                      `json:"proposer"`
+	Meta      ChangeMeta                     `json:"meta"`
+	CreatedAt time.Time                      `json:"created_at"`
+	ExpiresAt time.Time                      `json:"expires_at"`
+}
+
+// Approve применяет предложение от имени principal из контекста, который должен отличаться
+// от автора. Транзакция проверяет, что затронутые ключи не менялись с момента предложения.
+func (rtc *RealTimeConfig) Approve(ctx context.Context, id string) error {
+	approver, ok := PrincipalFromContext(ctx)
+	if !ok || approver.Name == "" {
+		return fmt.Errorf("%w: no principal in context", ErrAccessDenied)
+	}
+
+	kv, record, err := rtc.getProposal(ctx, id)
+	if err != nil {
+		return err
+	}
+	if record.Proposer.Name == approver.Name {
+		return ErrSelfApproval
+	}
+
+	names := make([]ConfigName, 0, len(record.Values))
+	for name := range record.Values {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+
+	values := make(map[ConfigName]any, len(names))
+	for _, name := range names {
+		meta, ok := rtc.schema[name]
+		if !ok {
+			return fmt.Errorf("unknown config field: %s", name)
+		}
+
+		val, err := rtc.decodeField(ctx, meta, record.Values[name])
+		if err != nil {
+			return fmt.Errorf("unmarshal failed for proposal %s: %w", id, err)
+		}
+		// предложение могло быть создано экземпляром с другими правилами
+		if err = checkRules(name, meta, val); err != nil {
+			return err
+		}
+		if err = rtc.authorize(ctx, name, record.Meta.Operation, val); err != nil {
+			return err
+		}
+		values[name] = val
+	}
+
+	changes := make([]change, 0, len(names))
+	for _, name := range names {
+		changes = append(changes, change{name: name, field: rtc.schema[name], scope: BaseScope, value: values[name], present: true})
+	}
+	// конфиг мог измениться с момента предложения
+	if err = rtc.validateChanges(changes); err != nil {
+		return err
+	}
+
+	changeMeta := record.Meta
+	changeMeta.ApprovedBy = approver.Name
+	audit, err := auditRecord(WithChangeMeta(ctx, changeMeta), record.Meta.Operation)
+	if err != nil {
+		return err
+	}
+
+	cmps := []clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(string(kv.Key)), "=", kv.ModRevision)}
+	ops := []clientv3.Op{clientv3.OpDelete(string(kv.Key))}
+	for _, name := range names {
+		key := rtc.prefix + "/" + string(name)
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", record.Revisions[name]))
+		ops = append(ops, clientv3.OpPut(key, string(record.Values[name])), clientv3.OpPut(rtc.auditKey(name), audit))
+	}
+
+	txnResp, err := rtc.client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return fmt.Errorf("approve transaction failed: %w", err)
+	}
+	if !txnResp.Succeeded {
+		if _, _, err = rtc.getProposal(ctx, id); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: proposal %s", ErrProposalStale, id)
+	}
+
+	rtc.applyChanges(changes)
+
+	return nil
+}
+
+// Reject отклоняет предложение от имени principal из контекста. Доступ проверяется так же,
+// как в Approve. Отказ записывается в prefix/_audit/rejected/<id> с Reason из WithChangeMeta.
+func (rtc *RealTimeConfig) Reject(ctx context.Context, id string) error {
+	rejecter, ok := PrincipalFromContext(ctx)
+	if !ok || rejecter.Name == "" {
+		return fmt.Errorf("%w: no principal in context", ErrAccessDenied)
+	}
+
+	kv, record, err := rtc.getProposal(ctx, id)
+	if err != nil {
+		return err
+	}
+	p, err := rtc.proposal(ctx, kv, record)
+	if err != nil {
+		return err
+	}
+	for name, raw := range record.Values {
+		meta, ok := rtc.schema[name]
+		if !ok {
+			continue
+		}
+		val, err := rtc.decodeField(ctx, meta, raw)
+		if err != nil {
+			return fmt.Errorf("unmarshal failed for proposal %s: %w", id, err)
+		}
+		if err = rtc.authorize(ctx, name, record.Meta.Operation, val); err != nil {
+			return err
+		}
+	}
+
+	meta, _ := ChangeMetaFromContext(ctx)
+	data, err := json.Marshal(RejectedProposal{
+		Proposal:   *p,
+		RejectedBy: rejecter,
+		Reason:     meta.Reason,
+		Time:       time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	txnResp, err := rtc.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(string(kv.Key)), "=", kv.ModRevision)).
+		Then(clientv3.OpDelete(string(kv.Key)), clientv3.OpPut(rtc.rejectedAuditKey(id), string(data))).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("reject transaction failed: %w", err)
+	}
+	if !txnResp.Succeeded {
+		return fmt.Errorf("%w: %s", ErrProposalNotFound, id)
+	}
+
+	return nil
+}
+
+// ListRejectedProposals возвращает записи аудита об отклонённых предложениях, старые первыми
+func (rtc *RealTimeConfig) ListRejectedProposals(ctx context.Context) ([]RejectedProposal, error) {
+	resp, err := rtc.client.Get(ctx, rtc.rejectedAuditKey(""), clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	rejected := make([]RejectedProposal, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var r RejectedProposal
+		if err = json.Unmarshal(kv.Value, &r); err != nil {
+			return nil, fmt.Errorf("unmarshal failed for rejected proposal %s: %w", kv.Key, err)
+		}
+		rejected = append(rejected, r)
+	}
+
+	return rejected, nil
+}
+
+// GetProposal возвращает предложение по id
+func (rtc *RealTimeConfig) GetProposal(ctx context.Context, id string) (*Proposal, error) {
+	kv, record, err := rtc.getProposal(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return rtc.proposal(ctx, kv, record)
+}
+
+// ListProposals возвращает ожидающие одобрения предложения, старые первыми
+func (rtc *RealTimeConfig) ListProposals(ctx context.Context) ([]Proposal, error) {
+	resp, err := rtc.client.Get(ctx, rtc.proposalKey(""), clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	proposals := make([]Proposal, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var record proposalRecord
+		if err = json.Unmarshal(kv.Value, &record); err != nil {
+			return nil, fmt.Errorf("unmarshal failed for proposal %s: %w", kv.Key, err)
+		}
+
+		p, err := rtc.proposal(ctx, kv, record)
+		if err != nil {
+			return nil, err
+		}
+		proposals = append(proposals, *p)
+	}
+
+	sort.Slice(proposals, func(i, j int) bool {
+		return proposals[i].CreatedAt.Before(proposals[j].CreatedAt)
+	})
+
+	return proposals, nil
+}
+
+// propose сохраняет закодированные значения как предложение с lease на время WithApprovalTTL
+func (rtc *RealTimeConfig) propose(ctx context.Context, values map[ConfigName]json.RawMessage, op Operation) (string, error) {
+	proposer, ok := PrincipalFromContext(ctx)
+	if !ok || proposer.Name == "" {
+		return "", fmt.Errorf("%w: no principal in context", ErrAccessDenied)
+	}
+
+	ops := make([]clientv3.Op, 0, len(values))
+	for name := range values {
+		ops = append(ops, clientv3.OpGet(rtc.prefix+"/"+string(name)))
+	}
+	getResp, err := rtc.client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return "", fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	revisions := make(map[ConfigName]int64, len(values))
+	for _, resp := range getResp.Responses {
+		for _, kv := range resp.GetResponseRange().Kvs {
+			revisions[ConfigName(strings.TrimPrefix(string(kv.Key), rtc.prefix+"/"))] = kv.ModRevision
+		}
+	}
+
+	now := time.Now().UTC()
+	meta, _ := ChangeMetaFromContext(ctx)
+	if meta.Author == "" {
+		meta.Author = proposer.Name
+	}
+	meta.Operation = op
+	meta.Time = now
+
+	ttl := rtc.opts.approvalTTL
+	record := proposalRecord{
+		Values:    values,
+		Revisions: revisions,
+		Proposer:  proposer,
+		Meta:      meta,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("marshal error: %w", err)
+	}
+
+	lease, err := rtc.client.Grant(ctx, int64(math.Ceil(ttl.Seconds())))
+	if err != nil {
+		return "", fmt.Errorf("lease grant failed: %w", err)
+	}
+	// без записи предложения lease никому не нужен, в том числе после отмены ctx
+	defer func() {
+		if err != nil {
+			rtc.revokeLease(lease.ID, "proposal")
+		}
+	}()
+
+	id := fmt.Sprintf("%019d-%08x", now.UnixNano(), rand.Uint32())
+	if _, err = rtc.client.Put(ctx, rtc.proposalKey(id), string(data), clientv3.WithLease(lease.ID)); err != nil {
+		return "", fmt.Errorf("etcd put failed: %w", err)
+	}
+
+	return id, nil
+}
+
+// requireNoApproval запрещает менять поля с approval:"required" в обход предложений
+func (rtc *RealTimeConfig) requireNoApproval(name ConfigName, op Operation) error {
+	if rtc.schema[name].Approval {
+		return fmt.Errorf("%w: %s cannot be changed by %s", ErrApprovalRequired, name, op)
+	}
+	return nil
+}
+
+func (rtc *RealTimeConfig) getProposal(ctx context.Context, id string) (*mvccpb.KeyValue, proposalRecord, error) {
+	resp, err := rtc.client.Get(ctx, rtc.proposalKey(id))
+	if err != nil {
+		return nil, proposalRecord{}, fmt.Errorf("etcd get failed: %w", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, proposalRecord{}, fmt.Errorf("%w: %s", ErrProposalNotFound, id)
+	}
+
+	var record proposalRecord
+	if err = json.Unmarshal(resp.Kvs[0].Value, &record); err != nil {
+		return nil, proposalRecord{}, fmt.Errorf("unmarshal failed for proposal %s: %w", id, err)
+	}
+
+	return resp.Kvs[0], record, nil
+}
+
+func (rtc *RealTimeConfig) proposal(ctx context.Context, kv *mvccpb.KeyValue, record proposalRecord) (*Proposal, error) {
+	p := &Proposal{
+		ID:        strings.TrimPrefix(string(kv.Key), rtc.proposalKey("")),
+		Values:    make(map[ConfigName]any, len(record.Values)),
+		Proposer:  record.Proposer,
+		Meta:      record.Meta,
+		CreatedAt: record.CreatedAt,
+		ExpiresAt: record.ExpiresAt,
+	}
+
+	for name, raw := range record.Values {
+		meta, ok := rtc.schema[name]
+		if !ok {
+			p.Values[name] = string(raw)
+			continue
+		}
+		if meta.Secret {
+			p.Values[name] = RedactedValue
+			continue
+		}
+
+		val, err := rtc.decodeField(ctx, meta, raw)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal failed for proposal %s: %w", p.ID, err)
+		}
+		p.Values[name] = val
+	}
+
+	return p, nil
+}
+
+func (rtc *RealTimeConfig) proposalKey(id string) string {
+	return rtc.prefix + "/" + proposalsDir + "/" + id
+}
+
+func (rtc *RealTimeConfig) rejectedAuditKey(id string) string {
+	return rtc.prefix + "/" + rejectedAuditDir + "/" + id
+}
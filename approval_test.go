@@ -0,0 +1,189 @@
+package konfig
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestRealTimeConfig_Approval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+	client := srv.Client
+
+	prefix := "/test/config/approval"
+
+	type Config struct {
+		Limit    int    `etcd:"limit" approval:"required"`
+		LogLevel string `etcd:"log_level"`
+	}
+
+	cfg := &Config{Limit: 10, LogLevel: "info"}
+	readOnly := AuthorizerFunc(func(_ context.Context, req AccessRequest) error {
+		if req.Principal.Name == "mallory" {
+			return errors.New("read-only principal")
+		}
+		return nil
+	})
+	rtc, err := NewRealTimeConfig(ctx, client, prefix, cfg, WithApprovalTTL(2*time.Second), WithAuthorizer(readOnly))
+	require.NoError(t, err)
+
+	alice := WithPrincipal(ctx, Principal{Name: "alice"})
+	bob := WithPrincipal(ctx, Principal{Name: "bob"})
+
+	propose := func(t *testing.T, ctx context.Context, values map[ConfigName]any) string {
+		var pending *PendingApprovalError
+		require.ErrorAs(t, rtc.SetMany(ctx, values), &pending)
+		return pending.ProposalID
+	}
+
+	t.Run("Approve", func(t *testing.T) {
+		id := propose(t, WithChangeMeta(alice, ChangeMeta{Reason: "more traffic"}),
+			map[ConfigName]any{"limit": 20, "log_level": "debug"})
+		assert.Equal(t, 10, cfg.Limit)
+		assert.Equal(t, "info", cfg.LogLevel)
+
+		p, err := rtc.GetProposal(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, map[ConfigName]any{"limit": 20, "log_level": "debug"}, p.Values)
+		assert.Equal(t, "alice", p.Proposer.Name)
+		assert.False(t, p.Meta.Time.IsZero())
+
+		assert.ErrorIs(t, rtc.Approve(alice, id), ErrSelfApproval)
+		assert.ErrorIs(t, rtc.Approve(ctx, id), ErrAccessDenied)
+
+		require.NoError(t, rtc.Approve(bob, id))
+		assert.Equal(t, 20, cfg.Limit)
+		assert.Equal(t, "debug", cfg.LogLevel)
+
+		history, err := rtc.GetKeyHistory(ctx, "limit", 0, 1)
+		require.NoError(t, err)
+		require.NotNil(t, history[0].Meta)
+		assert.Equal(t, "alice", history[0].Meta.Author)
+		assert.Equal(t, "bob", history[0].Meta.ApprovedBy)
+		assert.Equal(t, "more traffic", history[0].Meta.Reason)
+
+		assert.ErrorIs(t, rtc.Approve(bob, id), ErrProposalNotFound)
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		first := propose(t, alice, map[ConfigName]any{"limit": 30})
+		second := propose(t, alice, map[ConfigName]any{"limit": 40})
+
+		require.NoError(t, rtc.Approve(bob, second))
+		assert.ErrorIs(t, rtc.Approve(bob, first), ErrProposalStale)
+		assert.Equal(t, 40, cfg.Limit)
+
+		require.NoError(t, rtc.Reject(bob, first))
+	})
+
+	t.Run("Reject and list", func(t *testing.T) {
+		id := propose(t, alice, map[ConfigName]any{"limit": 50})
+
+		proposals, err := rtc.ListProposals(ctx)
+		require.NoError(t, err)
+		require.Len(t, proposals, 1)
+		assert.Equal(t, id, proposals[0].ID)
+
+		assert.ErrorIs(t, rtc.Reject(ctx, id), ErrAccessDenied)
+		assert.ErrorIs(t, rtc.Reject(WithPrincipal(ctx, Principal{Name: "mallory"}), id), ErrAccessDenied)
+		_, err = rtc.GetProposal(ctx, id)
+		require.NoError(t, err)
+
+		require.NoError(t, rtc.Reject(WithChangeMeta(bob, ChangeMeta{Reason: "too high"}), id))
+		assert.ErrorIs(t, rtc.Reject(bob, id), ErrProposalNotFound)
+		assert.Equal(t, 40, cfg.Limit)
+
+		rejected, err := rtc.ListRejectedProposals(ctx)
+		require.NoError(t, err)
+		last := rejected[len(rejected)-1]
+		assert.Equal(t, id, last.ID)
+		assert.Equal(t, "alice", last.Proposer.Name)
+		assert.Equal(t, "bob", last.RejectedBy.Name)
+		assert.Equal(t, "too high", last.Reason)
+		assert.Equal(t, map[ConfigName]any{"limit": float64(50)}, last.Values)
+	})
+
+	t.Run("Rules", func(t *testing.T) {
+		// экземпляр с более строгими правилами не применяет предложение, созданное до их ужесточения
+		type StrictConfig struct {
+			Limit    int    `etcd:"limit" validate:"max=100" approval:"required"`
+			LogLevel string `etcd:"log_level"`
+		}
+		strict, err := NewRealTimeConfig(ctx, client, prefix, &StrictConfig{}, WithoutWatch())
+		require.NoError(t, err)
+
+		id := propose(t, alice, map[ConfigName]any{"limit": 500})
+		assert.ErrorIs(t, strict.Approve(bob, id), ErrValidation)
+		assert.Equal(t, 40, cfg.Limit)
+
+		require.NoError(t, rtc.Reject(bob, id))
+	})
+
+	t.Run("Expires", func(t *testing.T) {
+		id := propose(t, alice, map[ConfigName]any{"limit": 60})
+
+		key := rtc.proposalKey(id)
+		srv.Await(t, key, func(kvs map[string][]byte) bool {
+			return kvs[key] == nil
+		})
+		_, err := rtc.GetProposal(ctx, id)
+		assert.ErrorIs(t, err, ErrProposalNotFound)
+	})
+
+	t.Run("No bypass", func(t *testing.T) {
+		assert.NoError(t, rtc.Set(ctx, "log_level", "warn"))
+		assert.ErrorIs(t, rtc.Set(ctx, "limit", 70), ErrAccessDenied)
+		assert.ErrorIs(t, rtc.SetOverride(alice, HostScope(), "limit", 70), ErrApprovalRequired)
+		assert.ErrorIs(t, rtc.SetWithTTL(alice, "limit", 70, time.Minute), ErrApprovalRequired)
+		assert.ErrorIs(t, rtc.StartRollout(alice, "limit", 70, 10), ErrApprovalRequired)
+
+		_, err := rtc.Schedule(alice, "limit", 70, time.Now())
+		assert.ErrorIs(t, err, ErrApprovalRequired)
+	})
+}
+
+func TestRealTimeConfig_ProposalLeaseRevoke(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+	client := srv.Client
+
+	type Config struct {
+		Banner string `etcd:"banner" approval:"required"`
+	}
+
+	rtc, err := NewRealTimeConfig(ctx, client, "/test/config/approval/revoke", &Config{})
+	require.NoError(t, err)
+
+	leases := func() map[clientv3.LeaseID]bool {
+		resp, err := client.Leases(ctx)
+		require.NoError(t, err)
+		ids := make(map[clientv3.LeaseID]bool, len(resp.Leases))
+		for _, l := range resp.Leases {
+			ids[l.ID] = true
+		}
+		return ids
+	}
+	before := leases()
+
+	// предложение больше лимита запроса etcd: lease выдан, но запись не проходит
+	alice := WithPrincipal(ctx, Principal{Name: "alice"})
+	err = rtc.Set(alice, "banner", strings.Repeat("x", 2<<20))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrApprovalRequired)
+
+	for id := range leases() {
+		assert.True(t, before[id], "lease %x was not revoked", id)
+	}
+}
@@ -0,0 +1,83 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const changesAuditDir = "_audit/changes"
+
+// ChangeMeta сведения об изменении: кто, зачем и по какой задаче. Operation и Time
+// заполняются библиотекой, Author по умолчанию берётся из principal контекста.
+// ApprovedBy заполняется при одобрении изменения поля с approval:"required".
+type ChangeMeta struct {
+	Author     string    `json:"author,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Ticket     string    `json:"ticket,omitempty"`
+	ApprovedBy string    `json:"approved_by,omitempty"`
+	Operation  Operation `json:"operation,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+type changeMetaKey struct{}
+
+// WithChangeMeta возвращает контекст, изменения из которого записываются в аудит с этими сведениями:
+//
+//	ctx = konfig.WithChangeMeta(ctx, konfig.ChangeMeta{Author: "alice", Reason: "sale", Ticket: "OPS-42"})
+//	err = rtc.Set(ctx, "rate_limit", 500)
+func WithChangeMeta(ctx context.Context, meta ChangeMeta) context.Context {
+	return context.WithValue(ctx, changeMetaKey{}, meta)
+}
+
+// ChangeMetaFromContext возвращает сведения об изменении из контекста
+func ChangeMetaFromContext(ctx context.Context) (ChangeMeta, bool) {
+	meta, ok := ctx.Value(changeMetaKey{}).(ChangeMeta)
+	return meta, ok
+}
+
+// auditRecord возвращает запись аудита для изменения из контекста. Она кладётся в
+// prefix/_audit/changes/<key> в одной транзакции с изменением, поэтому ревизия записи
+// совпадает с ревизией изменения и по ней GetHistory находит сведения для каждой версии ключа.
+func auditRecord(ctx context.Context, op Operation) (string, error) {
+	meta, _ := ChangeMetaFromContext(ctx)
+	if meta.Author == "" {
+		if p, ok := PrincipalFromContext(ctx); ok {
+			meta.Author = p.Name
+		}
+	}
+	meta.Operation = op
+	meta.Time = time.Now().UTC()
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return "", fmt.Errorf("marshal error: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// changeMeta возвращает сведения об изменении ключа, записанном в ревизии modRev
+func (rtc *RealTimeConfig) changeMeta(ctx context.Context, name ConfigName, modRev int64) (*ChangeMeta, error) {
+	resp, err := rtc.client.Get(ctx, rtc.auditKey(name), clientv3.WithRev(modRev))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 || resp.Kvs[0].ModRevision != modRev {
+		return nil, nil
+	}
+
+	var meta ChangeMeta
+	if err = json.Unmarshal(resp.Kvs[0].Value, &meta); err != nil {
+		return nil, fmt.Errorf("unmarshal failed for audit of %s: %w", name, err)
+	}
+
+	return &meta, nil
+}
+
+func (rtc *RealTimeConfig) auditKey(name ConfigName) string {
+	return rtc.prefix + "/" + changesAuditDir + "/" + string(name)
+}
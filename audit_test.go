@@ -0,0 +1,94 @@
+package konfig
+
+import (
+	"context"
+	"testing"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealTimeConfig_Audit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := konfigtest.New(t).Client
+
+	prefix := "/test/config/audit"
+
+	type Config struct {
+		RateLimit int    `etcd:"rate_limit" validate:"min=1"`
+		Mode      string `etcd:"mode"`
+	}
+
+	cfg := &Config{RateLimit: 100, Mode: "normal"}
+	rtc, err := NewRealTimeConfig(ctx, client, prefix, cfg)
+	require.NoError(t, err)
+
+	t.Run("SetMany", func(t *testing.T) {
+		saleCtx := WithChangeMeta(ctx, ChangeMeta{Author: "alice", Reason: "black friday", Ticket: "OPS-42"})
+		require.NoError(t, rtc.SetMany(saleCtx, map[ConfigName]any{"rate_limit": 500, "mode": "sale"}))
+		assert.Equal(t, 500, cfg.RateLimit)
+		assert.Equal(t, "sale", cfg.Mode)
+
+		history, err := rtc.GetKeyHistory(ctx, "rate_limit", 0, 1)
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		require.NotNil(t, history[0].Meta)
+		assert.Equal(t, "alice", history[0].Meta.Author)
+		assert.Equal(t, "black friday", history[0].Meta.Reason)
+		assert.Equal(t, "OPS-42", history[0].Meta.Ticket)
+		assert.Equal(t, OpSet, history[0].Meta.Operation)
+
+		modeHistory, err := rtc.GetKeyHistory(ctx, "mode", 0, 1)
+		require.NoError(t, err)
+		require.Len(t, modeHistory, 1)
+		assert.Equal(t, history[0].ModRev, modeHistory[0].ModRev)
+		assert.Equal(t, history[0].Meta, modeHistory[0].Meta)
+	})
+
+	t.Run("SetMany is atomic", func(t *testing.T) {
+		err := rtc.SetMany(ctx, map[ConfigName]any{"rate_limit": 0, "mode": "broken"})
+		assert.ErrorIs(t, err, ErrValidation)
+		assert.Equal(t, "sale", cfg.Mode)
+
+		assert.Error(t, rtc.SetMany(ctx, map[ConfigName]any{"mode": "x", "unknown": 1}))
+	})
+
+	t.Run("Author from principal", func(t *testing.T) {
+		opsCtx := WithPrincipal(ctx, Principal{Name: "bob"})
+		require.NoError(t, rtc.Set(WithChangeMeta(opsCtx, ChangeMeta{Reason: "sale over"}), "mode", "normal"))
+
+		history, err := rtc.GetKeyHistory(ctx, "mode", 0, 1)
+		require.NoError(t, err)
+		require.NotNil(t, history[0].Meta)
+		assert.Equal(t, "bob", history[0].Meta.Author)
+		assert.Equal(t, "sale over", history[0].Meta.Reason)
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		history, err := rtc.GetKeyHistory(ctx, "rate_limit", 0, 0)
+		require.NoError(t, err)
+		first := history[len(history)-1]
+		assert.Nil(t, first.Meta)
+
+		rbCtx := WithChangeMeta(ctx, ChangeMeta{Author: "carol", Ticket: "INC-7"})
+		require.NoError(t, rtc.RollbackKeyByRevision(rbCtx, "rate_limit", first.ModRev))
+
+		history, err = rtc.GetKeyHistory(ctx, "rate_limit", 0, 1)
+		require.NoError(t, err)
+		require.NotNil(t, history[0].Meta)
+		assert.Equal(t, OpRollback, history[0].Meta.Operation)
+		assert.Equal(t, "INC-7", history[0].Meta.Ticket)
+	})
+
+	t.Run("Direct writes have no metadata", func(t *testing.T) {
+		_, err := client.Put(ctx, prefix+"/mode", `"manual"`)
+		require.NoError(t, err)
+
+		history, err := rtc.GetKeyHistory(ctx, "mode", 0, 1)
+		require.NoError(t, err)
+		assert.Nil(t, history[0].Meta)
+	})
+}
@@ -0,0 +1,185 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+const deniedAuditDir = "_audit/denied"
+
+var ErrAccessDenied = errors.New("access denied")
+
+// Operation вид изменения, для которого запрашивается доступ
+type Operation string
+
+const (
+	OpSet       Operation = "set"
+	OpRollback  Operation = "rollback"
+	OpOverride  Operation = "override"
+	OpTemporary Operation = "temporary"
+	OpRollout   Operation = "rollout"
+	OpSchedule  Operation = "schedule"
+	OpImport    Operation = "import"
+)
+
+// Principal субъект, выполняющий изменение
+type Principal struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// AccessRequest запрос на изменение поля. Value - новое значение,
+// для удаления переопределений и отмены раскаток nil.
+type AccessRequest struct {
+	Principal Principal
+	Key       ConfigName
+	Operation Operation
+	Value     any
+	Field     FieldInfo
+}
+
+// Authorizer решает, разрешено ли изменение. Отказ возвращается ошибкой,
+// её текст попадает в аудит.
+type Authorizer interface {
+	Authorize(ctx context.Context, req AccessRequest) error
+}
+
+// AuthorizerFunc позволяет использовать функцию как Authorizer
+type AuthorizerFunc func(ctx context.Context, req AccessRequest) error
+
+func (f AuthorizerFunc) Authorize(ctx context.Context, req AccessRequest) error {
+	return f(ctx, req)
+}
+
+// TagPolicy встроенная политика по тегам полей owner и role:
+//
+//	Limit int `etcd:"limit" owner:"payments" role:"sre admin"`
+//
+// Поле может менять principal с именем или ролью owner либо с одной из ролей role.
+// Поля без тегов может менять любой principal. AdminRoles разрешены для всех полей.
+type TagPolicy struct {
+	AdminRoles []string
+}
+
+func (p TagPolicy) Authorize(_ context.Context, req AccessRequest) error {
+	if req.Principal.Name == "" {
+		return errors.New("no principal in context")
+	}
+	if req.Field.Owner == "" && len(req.Field.Roles) == 0 {
+		return nil
+	}
+
+	for _, role := range req.Principal.Roles {
+		if role == req.Field.Owner || slices.Contains(req.Field.Roles, role) || slices.Contains(p.AdminRoles, role) {
+			return nil
+		}
+	}
+	if req.Principal.Name == req.Field.Owner {
+		return nil
+	}
+
+	return fmt.Errorf("%s is not an owner of %s", req.Principal.Name, req.Key)
+}
+
+// SystemRole роль principal, от имени которого экземпляр сам меняет конфиг, например при
+// автооткате WithHealthProbe. Такие изменения не проходят Authorizer и одобрение: отказ оставил
+// бы сервис со сбойным значением. Роль в WithPrincipal этих прав не даёт, их получают только
+// изменения самой библиотеки.
+const SystemRole = "system"
+
+type (
+	principalKey struct{}
+	systemKey    struct{}
+)
+
+// WithPrincipal возвращает контекст, от имени которого выполняются изменения
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext возвращает principal из контекста
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// asSystem возвращает контекст изменений, которые экземпляр выполняет сам
+func (rtc *RealTimeConfig) asSystem(ctx context.Context) context.Context {
+	ctx = WithPrincipal(ctx, Principal{Name: rtc.opts.instanceID, Roles: []string{SystemRole}})
+	return context.WithValue(ctx, systemKey{}, true)
+}
+
+func isSystem(ctx context.Context) bool {
+	system, _ := ctx.Value(systemKey{}).(bool)
+	return system
+}
+
+// DeniedAccess запись аудита об отказе в доступе
+type DeniedAccess struct {
+	Principal Principal  `json:"principal"`
+	Key       ConfigName `json:"key"`
+	Operation Operation  `json:"operation"`
+	Value     any        `json:"value,omitempty"`
+	Reason    string     `json:"reason"`
+	Time      time.Time  `json:"time"`
+}
+
+// authorize проверяет доступ через Authorizer из WithAuthorizer. Отказ
+// записывается в prefix/_audit/denied/<id> и возвращается как ErrAccessDenied.
+func (rtc *RealTimeConfig) authorize(ctx context.Context, name ConfigName, op Operation, value any) error {
+	return rtc.checkAccess(ctx, name, op, value, true)
+}
+
+// checkAccess проверяет доступ как authorize. Без audit отказ не записывается в аудит:
+// так проверяются предпросмотры, которые ничего не меняют.
+func (rtc *RealTimeConfig) checkAccess(ctx context.Context, name ConfigName, op Operation, value any, audit bool) error {
+	if rtc.opts.authorizer == nil || isSystem(ctx) {
+		return nil
+	}
+
+	principal, _ := PrincipalFromContext(ctx)
+	err := rtc.opts.authorizer.Authorize(ctx, AccessRequest{
+		Principal: principal,
+		Key:       name,
+		Operation: op,
+		Value:     value,
+		Field:     rtc.fieldInfo(name),
+	})
+	if err == nil {
+		return nil
+	}
+	if !audit {
+		return fmt.Errorf("%w: %s %s: %w", ErrAccessDenied, op, name, err)
+	}
+
+	log.Printf("Access denied: %s %s %s: %v", principal.Name, op, name, err)
+
+	record := DeniedAccess{
+		Principal: principal,
+		Key:       name,
+		Operation: op,
+		Value:     redact(rtc.schema[name], value),
+		Reason:    err.Error(),
+		Time:      time.Now().UTC(),
+	}
+	if data, marshalErr := json.Marshal(record); marshalErr != nil {
+		log.Printf("Failed to audit denied access: %v", marshalErr)
+	} else {
+		id := fmt.Sprintf("%019d-%08x", record.Time.UnixNano(), rand.Uint32())
+		if _, putErr := rtc.client.Put(context.WithoutCancel(ctx), rtc.deniedAuditKey(id), string(data)); putErr != nil {
+			log.Printf("Failed to audit denied access: %v", putErr)
+		}
+	}
+
+	return fmt.Errorf("%w: %s %s: %w", ErrAccessDenied, op, name, err)
+}
+
+func (rtc *RealTimeConfig) deniedAuditKey(id string) string {
+	return rtc.prefix + "/" + deniedAuditDir + "/" + id
+}
@@ -0,0 +1,131 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestRealTimeConfig_Authorizer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := konfigtest.New(t).Client
+
+	prefix := "/test/config/auth"
+
+	type Config struct {
+		Limit    int    `etcd:"limit" owner:"payments" role:"sre"`
+		Token    string `etcd:"token" owner:"security" secret:"true"`
+		LogLevel string `etcd:"log_level"`
+	}
+
+	kp, err := NewFileKeyProvider(writeKeyFile(t, "k1", "k1"))
+	require.NoError(t, err)
+
+	rtc, err := NewRealTimeConfig(ctx, client, prefix, &Config{Limit: 10, Token: "t0", LogLevel: "info"},
+		WithKeyProvider(kp),
+		WithAuthorizer(TagPolicy{AdminRoles: []string{"admin"}}))
+	require.NoError(t, err)
+
+	payments := WithPrincipal(ctx, Principal{Name: "alice", Roles: []string{"payments"}})
+	sre := WithPrincipal(ctx, Principal{Name: "bob", Roles: []string{"sre"}})
+	admin := WithPrincipal(ctx, Principal{Name: "carol", Roles: []string{"admin"}})
+	intern := WithPrincipal(ctx, Principal{Name: "dave"})
+
+	denied := func(t *testing.T) []DeniedAccess {
+		resp, err := client.Get(ctx, prefix+"/"+deniedAuditDir+"/", clientv3.WithPrefix())
+		require.NoError(t, err)
+
+		records := make([]DeniedAccess, 0, len(resp.Kvs))
+		for _, kv := range resp.Kvs {
+			var r DeniedAccess
+			require.NoError(t, json.Unmarshal(kv.Value, &r))
+			records = append(records, r)
+		}
+		return records
+	}
+
+	t.Run("Owner and role", func(t *testing.T) {
+		assert.NoError(t, rtc.Set(payments, "limit", 20))
+		assert.NoError(t, rtc.Set(sre, "limit", 30))
+		assert.NoError(t, rtc.Set(admin, "token", "t1"))
+		assert.NoError(t, rtc.Set(intern, "log_level", "debug"))
+	})
+
+	t.Run("Denied and audited", func(t *testing.T) {
+		err := rtc.Set(intern, "limit", 40)
+		require.ErrorIs(t, err, ErrAccessDenied)
+
+		err = rtc.Set(payments, "token", "stolen")
+		require.ErrorIs(t, err, ErrAccessDenied)
+
+		assert.ErrorIs(t, rtc.Set(ctx, "log_level", "warn"), ErrAccessDenied)
+
+		val, err := rtc.Value("limit")
+		require.NoError(t, err)
+		assert.Equal(t, 30, val)
+
+		records := denied(t)
+		require.Len(t, records, 3)
+		assert.Equal(t, "dave", records[0].Principal.Name)
+		assert.Equal(t, ConfigName("limit"), records[0].Key)
+		assert.Equal(t, OpSet, records[0].Operation)
+		assert.Equal(t, float64(40), records[0].Value)
+		assert.Equal(t, RedactedValue, records[1].Value)
+		assert.Contains(t, records[2].Reason, "no principal")
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		history, err := rtc.GetKeyHistory(ctx, "limit", 0, 0)
+		require.NoError(t, err)
+		require.NotEmpty(t, history)
+		rev := history[len(history)-1].ModRev
+
+		err = rtc.RollbackKeyByRevision(intern, "limit", rev)
+		require.ErrorIs(t, err, ErrAccessDenied)
+		assert.Equal(t, OpRollback, denied(t)[3].Operation)
+
+		assert.ErrorIs(t, rtc.RollbackConfig(payments, rev), ErrAccessDenied)
+		require.NoError(t, rtc.RollbackKeyByRevision(payments, "limit", rev))
+
+		val, err := rtc.Value("limit")
+		require.NoError(t, err)
+		assert.Equal(t, 10, val)
+	})
+
+	t.Run("Other operations", func(t *testing.T) {
+		assert.ErrorIs(t, rtc.SetOverride(intern, HostScope(), "limit", 1), ErrAccessDenied)
+		assert.ErrorIs(t, rtc.SetWithTTL(intern, "limit", 1, time.Minute), ErrAccessDenied)
+		assert.ErrorIs(t, rtc.StartRollout(intern, "limit", 1, 10), ErrAccessDenied)
+
+		_, err := rtc.Schedule(intern, "limit", 1, time.Now().Add(time.Hour))
+		assert.ErrorIs(t, err, ErrAccessDenied)
+
+		id, err := rtc.Schedule(sre, "limit", 1, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.ErrorIs(t, rtc.CancelSchedule(intern, id), ErrAccessDenied)
+		assert.NoError(t, rtc.CancelSchedule(sre, id))
+	})
+
+	t.Run("Dry run not audited", func(t *testing.T) {
+		before := len(denied(t))
+
+		doc := strings.NewReader(`{"limit": 50}`)
+		_, err := rtc.Import(intern, doc, ImportOptions{Format: FormatJSON, DryRun: true})
+		require.ErrorIs(t, err, ErrAccessDenied)
+		assert.Len(t, denied(t), before)
+
+		doc = strings.NewReader(`{"limit": 50}`)
+		_, err = rtc.Import(intern, doc, ImportOptions{Format: FormatJSON})
+		require.ErrorIs(t, err, ErrAccessDenied)
+		assert.Len(t, denied(t), before+1)
+	})
+}
@@ -6,19 +6,34 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 var (
 	ErrWrongType = errors.New("cfg must be a pointer to struct")
+
+	errTxnConflict = errors.New("transaction conditions not met")
 )
 
 type ConfigName string
 
 type fieldSchema struct {
-	Type     reflect.Type
-	FieldIdx int
+	Name        ConfigName
+	Type        reflect.Type
+	FieldIdx    int
+	Description string
+	Rules       []Rule
+	Flags       []string
+	Secret      bool
+	Owner       string
+	Roles       []string
+	Approval    bool
+	// Derive выражение тега derive, по которому вычисляется поле
+	Derive string
 }
 
 type RealTimeConfig struct {
@@ -26,9 +41,44 @@ type RealTimeConfig struct {
 	prefix string
 	schema map[ConfigName]fieldSchema
 	cfg    any
+	// derived производные поля, deriveOrder порядок их вычисления
+	derived     map[ConfigName]derivedField
+	deriveOrder []ConfigName
+	opts        options
+
+	defaults map[ConfigName]any
+
+	// mu защищает поля cfg и layers от конкурентной записи из watch
+	mu sync.RWMutex
+	// layers значения полей по scope, эффективное значение выбирается по приоритету scope
+	layers map[ConfigName]map[string]any
+
+	// appliedRev ревизия etcd, до которой применены изменения; appliedCh закрывается при её росте.
+	// keyRevs ревизии последних применённых событий по ключам, чтобы отсеять повторы из watch.
+	appliedMu  sync.Mutex
+	appliedRev int64
+	appliedCh  chan struct{}
+	keyRevs    map[string]int64
+	// rejections значения, которые watch не смог применить, по ключам относительно префикса
+	rejections map[string]Rejection
+	// statusCh сигнализирует WithStatusReporting и WithRegistration, что статус изменился, statusDirty что
+	// с последней публикации применялись изменения конфига
+	statusCh    chan struct{}
+	statusDirty bool
+
+	// limits состояние WithDebounce и WithFlapProtection по ключам etcd
+	limitMu sync.Mutex
+	limits  map[string]*keyLimit
+
+	subsMu sync.Mutex
+	subs   map[chan Event]struct{}
+
+	// health наблюдения WithHealthProbe по полям
+	healthMu sync.Mutex
+	health   map[ConfigName]*healthWatch
 }
 
-func NewRealTimeConfig(ctx context.Context, cli *clientv3.Client, prefix string, cfg any) (*RealTimeConfig, error) {
+func NewRealTimeConfig(ctx context.Context, cli *clientv3.Client, prefix string, cfg any, opts ...Option) (*RealTimeConfig, error) {
 	t := reflect.TypeOf(cfg)
 	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
 		return nil, ErrWrongType
@@ -38,78 +88,270 @@ func NewRealTimeConfig(ctx context.Context, cli *clientv3.Client, prefix string,
 	if err != nil {
 		return nil, err
 	}
+	o := buildOptions(opts)
+	derived, deriveOrder, err := buildDerived(schema, o.derived)
+	if err != nil {
+		return nil, err
+	}
 
 	rtc := &RealTimeConfig{
 		client: cli,
 		prefix: prefix,
 		schema: schema,
 		cfg:    cfg,
+		opts:   o,
+
+		derived:     derived,
+		deriveOrder: deriveOrder,
+		layers:      make(map[ConfigName]map[string]any),
+
+		appliedCh:  make(chan struct{}),
+		keyRevs:    make(map[string]int64),
+		rejections: make(map[string]Rejection),
+		limits:     make(map[string]*keyLimit),
+		subs:       make(map[chan Event]struct{}),
+		health:     make(map[ConfigName]*healthWatch),
 	}
+	rtc.defaults = rtc.getDefaultValues()
 
-	if err = rtc.syncWithDefaults(ctx); err != nil {
+	if rtc.opts.keyProvider == nil {
+		for name, meta := range schema {
+			if meta.Secret {
+				return nil, fmt.Errorf("%w: field %s", ErrNoKeyProvider, name)
+			}
+		}
+	}
+
+	if !rtc.opts.skipSync {
+		if err = rtc.syncWithDefaults(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, scope := range rtc.opts.overrideScopes {
+		if err = validateScope(scope); err != nil {
+			return nil, err
+		}
+	}
+	if err = rtc.loadOverrides(ctx); err != nil {
 		return nil, err
 	}
+	rtc.mu.Lock()
+	rtc.derive(reflect.ValueOf(rtc.cfg).Elem(), nil)
+	rtc.mu.Unlock()
+
+	if rtc.opts.schemaVersion != "" {
+		if err = rtc.publishSchema(ctx); err != nil {
+			return nil, err
+		}
+	}
 
-	go rtc.watch(ctx)
+	if !rtc.opts.skipWatch {
+		go rtc.watch(ctx)
+	}
+	if rtc.opts.scheduler {
+		go rtc.runScheduler(ctx)
+	}
+	if rtc.opts.statusReport || rtc.opts.register {
+		rtc.statusCh = make(chan struct{}, 1)
+		rtc.statusChanged()
+		go rtc.reportStatus(ctx)
+	}
 
 	return rtc, nil
 }
 
+// Get читает из etcd эффективное значение поля: переопределение из scope
+// этого экземпляра, если оно есть, иначе базовое значение
 func (rtc *RealTimeConfig) Get(ctx context.Context, name ConfigName) (any, error) {
-	meta, ok := rtc.schema[name]
-	if !ok {
-		return nil, fmt.Errorf("unknown config field: %s", name)
+	resolved, err := rtc.Resolve(ctx, name)
+	if err != nil {
+		return nil, err
 	}
 
-	key := rtc.prefix + "/" + string(name)
-	resp, err := rtc.client.Get(ctx, key)
+	return resolved.Value, nil
+}
+
+func (rtc *RealTimeConfig) Set(ctx context.Context, name ConfigName, value any) error {
+	return rtc.set(ctx, name, value, OpSet)
+}
+
+func (rtc *RealTimeConfig) set(ctx context.Context, name ConfigName, value any, op Operation) error {
+	_, err := rtc.setMany(ctx, map[ConfigName]any{name: value}, op)
+	return err
+}
+
+// SetMany атомарно записывает значения нескольких полей одной транзакцией.
+// Если хотя бы одно значение не проходит проверку или конфиг с новыми значениями
+// нарушает инварианты Validator и WithValidator, не записывается ни одно.
+func (rtc *RealTimeConfig) SetMany(ctx context.Context, values map[ConfigName]any) error {
+	_, err := rtc.setMany(ctx, values, OpSet)
+	return err
+}
+
+// setMany возвращает ревизию записи
+func (rtc *RealTimeConfig) setMany(ctx context.Context, values map[ConfigName]any, op Operation) (int64, error) {
+	return rtc.setManyIf(ctx, values, op, nil)
+}
+
+// setManyIf как setMany, но записывает значения только при выполнении cmps и добавляет
+// в транзакцию extra. Если условия не выполнены, возвращает errTxnConflict.
+func (rtc *RealTimeConfig) setManyIf(ctx context.Context, values map[ConfigName]any, op Operation,
+	cmps []clientv3.Cmp, extra ...clientv3.Op) (int64, error) {
+	names := make([]ConfigName, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+
+	audit, err := auditRecord(ctx, op)
 	if err != nil {
-		return nil, fmt.Errorf("etcd get failed: %w", err)
+		return 0, err
+	}
+
+	converted := make(map[ConfigName]any, len(values))
+	encoded := make(map[ConfigName]json.RawMessage, len(values))
+	approval := false
+	ops := make([]clientv3.Op, 0, 2*len(values))
+	for _, name := range names {
+		meta, ok := rtc.schema[name]
+		if _, derived := rtc.derived[name]; derived {
+			return 0, fmt.Errorf("field %s is derived and cannot be set", name)
+		}
+		if !ok {
+			return 0, fmt.Errorf("unknown config field: %s", name)
+		}
+
+		convertedVal, err := convertType(values[name], meta.Type)
+		if err != nil {
+			return 0, fmt.Errorf("type conversion failed for field %s: %w", name, err)
+		}
+
+		val := reflect.ValueOf(convertedVal)
+		if val.Type() != meta.Type {
+			return 0, fmt.Errorf("invalid type after conversion for field %s: expected %s, got %s",
+				name, meta.Type, val.Type())
+		}
+
+		if err = checkRules(name, meta, convertedVal); err != nil {
+			return 0, err
+		}
+		if err = rtc.authorize(ctx, name, op, convertedVal); err != nil {
+			return 0, err
+		}
+
+		data, err := rtc.encodeField(ctx, meta, convertedVal)
+		if err != nil {
+			return 0, fmt.Errorf("marshal error: %w", err)
+		}
+
+		converted[name] = convertedVal
+		encoded[name] = data
+		approval = approval || meta.Approval
+		ops = append(ops,
+			clientv3.OpPut(rtc.prefix+"/"+string(name), string(data)),
+			clientv3.OpPut(rtc.auditKey(name), audit))
 	}
-	if len(resp.Kvs) == 0 {
-		return nil, fmt.Errorf("key not found in etcd: %s", key)
+
+	changes := make([]change, 0, len(names))
+	for _, name := range names {
+		changes = append(changes, change{name: name, field: rtc.schema[name], scope: BaseScope, value: converted[name], present: true})
+	}
+	if err = rtc.validateChanges(changes); err != nil {
+		return 0, err
 	}
 
-	ptr := reflect.New(meta.Type)
-	if err = json.Unmarshal(resp.Kvs[0].Value, ptr.Interface()); err != nil {
-		return nil, fmt.Errorf("unmarshal failed: %w", err)
+	// изменение с хотя бы одним полем approval:"required" целиком уходит на одобрение
+	if approval && !isSystem(ctx) {
+		id, err := rtc.propose(ctx, encoded, op)
+		if err != nil {
+			return 0, err
+		}
+		return 0, &PendingApprovalError{ProposalID: id}
 	}
 
-	return ptr.Elem().Interface(), nil
+	resp, err := rtc.client.Txn(ctx).If(cmps...).Then(append(ops, extra...)...).Commit()
+	if err != nil {
+		return 0, fmt.Errorf("etcd put failed: %w", err)
+	}
+	if !resp.Succeeded {
+		return 0, errTxnConflict
+	}
+
+	rtc.applyChanges(changes)
+
+	return resp.Header.Revision, nil
 }
 
-func (rtc *RealTimeConfig) Set(ctx context.Context, name ConfigName, value any) error {
+// Value возвращает текущее значение поля из памяти процесса без обращения к etcd
+func (rtc *RealTimeConfig) Value(name ConfigName) (any, error) {
 	meta, ok := rtc.schema[name]
+	if d, derived := rtc.derived[name]; derived {
+		meta, ok = d.fieldSchema, true
+	}
 	if !ok {
-		return fmt.Errorf("unknown config field: %s", name)
+		return nil, fmt.Errorf("unknown config field: %s", name)
 	}
 
-	convertedVal, err := convertType(value, meta.Type)
-	if err != nil {
-		return fmt.Errorf("type conversion failed for field %s: %w", name, err)
-	}
+	return rtc.fieldValue(meta), nil
+}
 
-	val := reflect.ValueOf(convertedVal)
-	if val.Type() != meta.Type {
-		return fmt.Errorf("invalid type after conversion for field %s: expected %s, got %s",
-			name, meta.Type, val.Type())
-	}
+// Config возвращает указатель на структуру конфига, переданную при создании
+// или созданную TenantManager для арендатора
+func (rtc *RealTimeConfig) Config() any {
+	return rtc.cfg
+}
 
-	key := rtc.prefix + "/" + string(name)
-	data, err := json.Marshal(convertedVal)
-	if err != nil {
-		return fmt.Errorf("marshal error: %w", err)
-	}
+func (rtc *RealTimeConfig) fieldValue(meta fieldSchema) any {
+	rtc.mu.RLock()
+	defer rtc.mu.RUnlock()
 
-	_, err = rtc.client.Put(ctx, key, string(data))
-	if err != nil {
-		return fmt.Errorf("etcd put failed: %w", err)
+	return reflect.ValueOf(rtc.cfg).Elem().Field(meta.FieldIdx).Interface()
+}
+
+// copyConfig возвращает копию структуры конфига, слайсы и map полей схемы копируются.
+// Вызывается под rtc.mu.
+func copyConfig(cfg any, schema map[ConfigName]fieldSchema) reflect.Value {
+	src := reflect.ValueOf(cfg).Elem()
+	dst := reflect.New(src.Type()).Elem()
+	dst.Set(src)
+	for _, meta := range schema {
+		field := dst.Field(meta.FieldIdx)
+		setFieldValue(field, field.Interface())
 	}
 
-	fieldValue := reflect.ValueOf(rtc.cfg).Elem().Field(meta.FieldIdx)
-	fieldValue.Set(val)
+	return dst
+}
+
+// setFieldValue записывает значение в поле cfg, слайсы и map копируются.
+// Вызывается под rtc.mu.
+func setFieldValue(fieldValue reflect.Value, value any) {
+	valToSet := reflect.ValueOf(value)
 
-	return nil
+	switch fieldValue.Kind() {
+	case reflect.Slice:
+		if valToSet.IsNil() {
+			fieldValue.Set(reflect.Zero(fieldValue.Type()))
+			return
+		}
+		newSlice := reflect.MakeSlice(fieldValue.Type(), valToSet.Len(), valToSet.Len())
+		reflect.Copy(newSlice, valToSet)
+		fieldValue.Set(newSlice)
+	case reflect.Map:
+		if valToSet.IsNil() {
+			fieldValue.Set(reflect.Zero(fieldValue.Type()))
+			return
+		}
+		newMap := reflect.MakeMap(fieldValue.Type())
+		for _, key := range valToSet.MapKeys() {
+			newMap.SetMapIndex(key, valToSet.MapIndex(key))
+		}
+		fieldValue.Set(newMap)
+	default:
+		fieldValue.Set(valToSet)
+	}
 }
 
 func buildSchema(cfg any) (map[ConfigName]fieldSchema, error) {
@@ -122,11 +364,41 @@ func buildSchema(cfg any) (map[ConfigName]fieldSchema, error) {
 		if etcdName == "" {
 			return nil, fmt.Errorf("field %s is missing etcd tag", field.Name)
 		}
+		if isReservedName(ConfigName(etcdName)) {
+			return nil, fmt.Errorf("field %s uses reserved etcd name %q", field.Name, etcdName)
+		}
 
-		schema[ConfigName(etcdName)] = fieldSchema{
-			Type:     field.Type,
-			FieldIdx: i,
+		rules, err := parseRules(field.Tag.Get("validate"), field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
 		}
+
+		meta := fieldSchema{
+			Name:        ConfigName(etcdName),
+			Type:        field.Type,
+			FieldIdx:    i,
+			Description: field.Tag.Get("desc"),
+			Rules:       rules,
+			Secret:      field.Tag.Get("secret") == "true",
+			Owner:       field.Tag.Get("owner"),
+			Derive:      field.Tag.Get("derive"),
+		}
+		if role := field.Tag.Get("role"); role != "" {
+			meta.Roles = strings.Fields(role)
+		}
+		if meta.Secret {
+			meta.Flags = append(meta.Flags, FlagSecret)
+		}
+		switch approval := field.Tag.Get("approval"); approval {
+		case "":
+		case "required":
+			meta.Approval = true
+			meta.Flags = append(meta.Flags, FlagApproval)
+		default:
+			return nil, fmt.Errorf("field %s: unknown approval mode %q", field.Name, approval)
+		}
+
+		schema[ConfigName(etcdName)] = meta
 	}
 
 	return schema, nil
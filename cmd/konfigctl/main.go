@@ -0,0 +1,564 @@
+// konfigctl управляет конфигом сервиса в etcd по файлу схемы,
+// используя ту же раскладку ключей и JSON-кодирование, что и библиотека.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	konfig "github.com/olefire/realtime-config-go"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const usage = `usage: konfigctl -prefix <prefix> (-schema <file> | -schema-version <version>) [-endpoints host:port,...] <command> [args]
+
+The schema is read from a file or, with -schema-version, from the schema a service
+published under <prefix>/.schema/<version>. Secret fields require -key-file or -key-env.
+Changes are audited with -author (defaults to $USER), -reason and -ticket. Keys that
+require approval are proposed by set and applied by approve of a different -author.
+The -author is taken as given and is not verified, so approval through konfigctl is
+not an identity check: restrict who can write the prefix with etcd access control.
+Values of secret fields are printed as <redacted> unless -reveal is given.
+
+commands:
+  schemas                            list service versions that published a schema
+  status                             print applied revision and rejected values of each instance
+  instances                          list live instances with their version and config hash
+  get [-reveal] <key>                print the value of a key
+  set [-wait quorum] <key> <json>    validate and write a value; with -wait block until the
+                                     fraction of registered instances applied it (see -timeout)
+  list [-reveal]                     print all keys with their values
+  history [-from rev] [-limit n] [key]
+                                     print change history of the prefix or a key
+  rollback -rev <rev> [key]          roll a key (or the whole config) back to a revision
+  rollback -version <n> <key>        roll a key back to its n-th version
+  diff [-replace] <file>             show changes an import of the file would make
+  export [-format json|yaml] [-o file]
+                                     write all keys as a JSON or YAML document
+  import [-dry-run] [-replace] <file>
+                                     validate a JSON or YAML document and write it atomically
+  rotate-keys [-batch n]             re-encrypt secret fields with the current key
+  proposals                          list changes waiting for approval
+  approve <id>                       apply a proposed change
+  reject <id>                        discard a proposed change
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "konfigctl:", err)
+		os.Exit(1)
+	}
+}
+
+type app struct {
+	rtc    *konfig.RealTimeConfig
+	schema *schema
+	out    io.Writer
+}
+
+func run(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("konfigctl", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(fs.Output(), usage) }
+	endpoints := fs.String("endpoints", envOr("KONFIG_ENDPOINTS", "localhost:2379"), "comma-separated etcd endpoints")
+	prefix := fs.String("prefix", os.Getenv("KONFIG_PREFIX"), "config prefix in etcd")
+	schemaPath := fs.String("schema", os.Getenv("KONFIG_SCHEMA"), "path to the schema file")
+	schemaVersion := fs.String("schema-version", "", "use the schema published by this service version")
+	timeout := fs.Duration("timeout", 10*time.Second, "operation timeout")
+	keyFile := fs.String("key-file", os.Getenv("KONFIG_KEY_FILE"), "key file for secret fields")
+	keyEnv := fs.String("key-env", "", "environment variable prefix with keys for secret fields")
+	author := fs.String("author", os.Getenv("USER"), "author recorded in the audit trail")
+	reason := fs.String("reason", "", "reason recorded in the audit trail")
+	ticket := fs.String("ticket", "", "change ticket recorded in the audit trail")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("command is required")
+	}
+	if *prefix == "" {
+		return errors.New("-prefix is required")
+	}
+	*prefix = strings.TrimSuffix(*prefix, "/")
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	ctx = konfig.WithChangeMeta(ctx, konfig.ChangeMeta{Author: *author, Reason: *reason, Ticket: *ticket})
+	if *author != "" {
+		ctx = konfig.WithPrincipal(ctx, konfig.Principal{Name: *author})
+	}
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(*endpoints, ","),
+		DialTimeout: *timeout,
+	})
+	if err != nil {
+		return fmt.Errorf("connect to etcd: %w", err)
+	}
+	defer cli.Close()
+
+	if fs.Arg(0) == "schemas" {
+		versions, err := konfig.ListSchemaVersions(ctx, cli, *prefix)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			fmt.Fprintln(out, v)
+		}
+		return nil
+	}
+	if fs.Arg(0) == "status" {
+		return printStatus(ctx, cli, *prefix, out)
+	}
+	if fs.Arg(0) == "instances" {
+		return printInstances(ctx, cli, *prefix, out)
+	}
+
+	var sch *schema
+	switch {
+	case *schemaPath != "" && *schemaVersion != "":
+		return errors.New("-schema and -schema-version are mutually exclusive")
+	case *schemaPath != "":
+		sch, err = loadSchema(*schemaPath)
+	case *schemaVersion != "":
+		sch, err = loadPublishedSchema(ctx, cli, *prefix, *schemaVersion)
+	default:
+		return errors.New("-schema or -schema-version is required")
+	}
+	if err != nil {
+		return err
+	}
+
+	opts := []konfig.Option{konfig.WithoutSync(), konfig.WithoutWatch()}
+	if sch.hasSecrets() {
+		kp, err := keyProvider(*keyFile, *keyEnv)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, konfig.WithKeyProvider(kp))
+	}
+
+	rtc, err := konfig.NewRealTimeConfig(ctx, cli, *prefix, sch.newConfig(), opts...)
+	if err != nil {
+		return err
+	}
+
+	a := &app{rtc: rtc, schema: sch, out: out}
+	cmdArgs := fs.Args()[1:]
+
+	switch cmd := fs.Arg(0); cmd {
+	case "get":
+		return a.get(ctx, cmdArgs)
+	case "set":
+		return a.set(ctx, cmdArgs)
+	case "list":
+		return a.list(ctx, cmdArgs)
+	case "history":
+		return a.history(ctx, cmdArgs)
+	case "rollback":
+		return a.rollback(ctx, cmdArgs)
+	case "diff":
+		return a.diff(ctx, cmdArgs)
+	case "export":
+		return a.export(ctx, cmdArgs)
+	case "import":
+		return a.importFile(ctx, cmdArgs)
+	case "rotate-keys":
+		return a.rotateKeys(ctx, cmdArgs)
+	case "proposals":
+		return a.proposals(ctx)
+	case "approve":
+		return a.approve(ctx, cmdArgs)
+	case "reject":
+		return a.reject(ctx, cmdArgs)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func (a *app) get(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	reveal := fs.Bool("reveal", false, "print values of secret fields")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: get [-reveal] <key>")
+	}
+
+	name := konfig.ConfigName(fs.Arg(0))
+	val, err := a.rtc.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	return a.printJSON(a.redact(name, val, *reveal))
+}
+
+func (a *app) set(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	wait := fs.Float64("wait", 0, "wait until this fraction of registered instances applies the value")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: set [-wait quorum] <key> <json>")
+	}
+
+	name := konfig.ConfigName(fs.Arg(0))
+	val, err := a.schema.parseValue(name, []byte(fs.Arg(1)))
+	if err != nil {
+		return err
+	}
+
+	if *wait > 0 {
+		err = a.rtc.SetAndWait(ctx, name, val, *wait)
+	} else {
+		err = a.rtc.Set(ctx, name, val)
+	}
+
+	var pending *konfig.PendingApprovalError
+	if errors.As(err, &pending) {
+		_, err = fmt.Fprintf(a.out, "proposal %s is waiting for approval\n", pending.ProposalID)
+	}
+	return err
+}
+
+func (a *app) proposals(ctx context.Context) error {
+	proposals, err := a.rtc.ListProposals(ctx)
+	if err != nil {
+		return err
+	}
+
+	return a.printJSON(proposals)
+}
+
+func (a *app) approve(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: approve <id>")
+	}
+
+	return a.rtc.Approve(ctx, args[0])
+}
+
+func (a *app) reject(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: reject <id>")
+	}
+
+	return a.rtc.Reject(ctx, args[0])
+}
+
+func (a *app) list(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	reveal := fs.Bool("reveal", false, "print values of secret fields")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: list [-reveal]")
+	}
+
+	values, err := a.current(ctx)
+	if err != nil {
+		return err
+	}
+	for name, val := range values {
+		values[name] = a.redact(name, val, *reveal)
+	}
+
+	return a.printJSON(values)
+}
+
+// redact скрывает значение секретного поля, если вывод секретов не запрошен явно
+func (a *app) redact(name konfig.ConfigName, val any, reveal bool) any {
+	if !reveal && a.schema.isSecret(name) {
+		return konfig.RedactedValue
+	}
+	return val
+}
+
+func (a *app) history(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	from := fs.Int64("from", 0, "start from this revision")
+	limit := fs.Int64("limit", 20, "maximum number of entries")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var (
+		entries []konfig.HistoryEntry
+		err     error
+	)
+	switch fs.NArg() {
+	case 0:
+		entries, err = a.rtc.GetHistory(ctx, *from, *limit)
+	case 1:
+		entries, err = a.rtc.GetKeyHistory(ctx, fs.Arg(0), *from, *limit)
+	default:
+		return errors.New("usage: history [-from rev] [-limit n] [key]")
+	}
+	if err != nil {
+		return err
+	}
+
+	return a.printJSON(entries)
+}
+
+func (a *app) rollback(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	rev := fs.Int64("rev", 0, "target revision")
+	version := fs.Int64("version", 0, "target key version")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch {
+	case *rev > 0 && *version > 0:
+		return errors.New("-rev and -version are mutually exclusive")
+	case *rev > 0 && fs.NArg() == 0:
+		return a.rtc.RollbackConfig(ctx, *rev)
+	case *rev > 0 && fs.NArg() == 1:
+		return a.rtc.RollbackKeyByRevision(ctx, konfig.ConfigName(fs.Arg(0)), *rev)
+	case *version > 0 && fs.NArg() == 1:
+		return a.rtc.RollbackKeyByVersion(ctx, konfig.ConfigName(fs.Arg(0)), *version)
+	default:
+		return errors.New("usage: rollback -rev <rev> [key] | rollback -version <n> <key>")
+	}
+}
+
+func (a *app) diff(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := fs.String("format", "", "document format: json or yaml (by file extension by default)")
+	replace := fs.Bool("replace", false, "reset keys missing from the file to their defaults")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: diff [-format json|yaml] [-replace] <file>")
+	}
+
+	changes, err := a.importDocument(ctx, fs.Arg(0), *format, *replace, true)
+	if err != nil {
+		return err
+	}
+
+	return a.printChanges(changes)
+}
+
+func (a *app) export(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("o", "", "output file (stdout by default)")
+	format := fs.String("format", "", "document format: json or yaml (by file extension by default)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return a.rtc.Export(ctx, a.out, formatOf(*format, ""))
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	if err = a.rtc.Export(ctx, f, formatOf(*format, *output)); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (a *app) importFile(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only show the changes")
+	format := fs.String("format", "", "document format: json or yaml (by file extension by default)")
+	replace := fs.Bool("replace", false, "reset keys missing from the file to their defaults")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: import [-dry-run] [-format json|yaml] [-replace] <file>")
+	}
+
+	changes, err := a.importDocument(ctx, fs.Arg(0), *format, *replace, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	return a.printChanges(changes)
+}
+
+func (a *app) rotateKeys(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	batch := fs.Int("batch", 64, "keys per transaction")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	n, err := a.rtc.RotateKeys(ctx, *batch)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(a.out, "re-encrypted %d keys\n", n)
+	return err
+}
+
+func (a *app) importDocument(ctx context.Context, path, format string, replace, dryRun bool) ([]konfig.Change, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	opts := konfig.ImportOptions{
+		Format: formatOf(format, path),
+		Mode:   konfig.ImportMerge,
+		DryRun: dryRun,
+	}
+	if replace {
+		opts.Mode = konfig.ImportReplace
+	}
+
+	return a.rtc.Import(ctx, f, opts)
+}
+
+// formatOf возвращает явно заданный формат или определяет его по расширению файла
+func formatOf(format, path string) konfig.Format {
+	if format != "" {
+		return konfig.Format(format)
+	}
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return konfig.FormatYAML
+	default:
+		return konfig.FormatJSON
+	}
+}
+
+// current читает значения всех ключей схемы, отсутствующие в etcd пропускаются
+func (a *app) current(ctx context.Context) (map[konfig.ConfigName]any, error) {
+	values := make(map[konfig.ConfigName]any)
+	for _, name := range a.schema.names() {
+		val, err := a.rtc.Get(ctx, name)
+		if err != nil {
+			if errors.Is(err, konfig.ErrKeyNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		values[name] = val
+	}
+
+	return values, nil
+}
+
+func (a *app) printChanges(changes []konfig.Change) error {
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(a.out, "no changes")
+		return err
+	}
+
+	for _, c := range changes {
+		newJSON, err := json.Marshal(c.New)
+		if err != nil {
+			return err
+		}
+		if c.Created {
+			fmt.Fprintf(a.out, "+ %s: %s\n", c.Key, newJSON)
+			continue
+		}
+
+		oldJSON, err := json.Marshal(c.Old)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(a.out, "~ %s: %s -> %s\n", c.Key, oldJSON, newJSON)
+	}
+
+	return nil
+}
+
+func printStatus(ctx context.Context, cli *clientv3.Client, prefix string, out io.Writer) error {
+	statuses, err := konfig.ListInstanceStatus(ctx, cli, prefix)
+	if err != nil {
+		return err
+	}
+	if len(statuses) == 0 {
+		_, err = fmt.Fprintln(out, "no instances report status")
+		return err
+	}
+
+	for _, st := range statuses {
+		fmt.Fprintf(out, "%s\tapplied %d\tupdated %s\n", st.InstanceID, st.AppliedRevision, st.UpdatedAt.Format(time.RFC3339))
+		keys := make([]string, 0, len(st.Rejections))
+		for key := range st.Rejections {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			r := st.Rejections[key]
+			fmt.Fprintf(out, "  rejected %s at revision %d: %s\n", key, r.Revision, r.Error)
+		}
+	}
+
+	return nil
+}
+
+func printInstances(ctx context.Context, cli *clientv3.Client, prefix string, out io.Writer) error {
+	instances, err := konfig.ListInstances(ctx, cli, prefix)
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		_, err = fmt.Fprintln(out, "no instances registered")
+		return err
+	}
+
+	for _, i := range instances {
+		fmt.Fprintf(out, "%s\t%s\t%s\tapplied %d\tconfig %.12s\n", i.InstanceID, i.Hostname, i.Version, i.AppliedRevision, i.ConfigHash)
+	}
+
+	return nil
+}
+
+func (a *app) printJSON(v any) error {
+	enc := json.NewEncoder(a.out)
+	enc.SetIndent("", "  ")
+	// вывод читает человек, поэтому <redacted> не экранируется
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+func keyProvider(keyFile, keyEnv string) (konfig.KeyProvider, error) {
+	switch {
+	case keyFile != "":
+		return konfig.NewFileKeyProvider(keyFile)
+	case keyEnv != "":
+		return konfig.NewEnvKeyProvider(keyEnv)
+	default:
+		return nil, errors.New("schema has secret fields: -key-file or -key-env is required")
+	}
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	konfig "github.com/olefire/realtime-config-go"
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_Rules(t *testing.T) {
+	srv := konfigtest.New(t)
+	dir := t.TempDir()
+
+	prefix := "/test/konfigctl/rules"
+
+	schemaPath := filepath.Join(dir, "schema.json")
+	require.NoError(t, os.WriteFile(schemaPath, []byte(`{"fields": [
+		{"name": "retries", "type": "int", "default": 3, "rules": [{"name": "min", "arg": "1"}]},
+		{"name": "mode", "type": "string", "default": "prod", "rules": [{"name": "oneof", "arg": "dev prod"}]}
+	]}`), 0o600))
+
+	ctl := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := run(append([]string{"-endpoints", srv.Endpoint, "-prefix", prefix, "-schema", schemaPath}, args...), &out)
+		return out.String(), err
+	}
+	writeFile := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+		return path
+	}
+	get := func(key string) string {
+		out, err := ctl("get", key)
+		require.NoError(t, err)
+		return out
+	}
+
+	_, err := ctl("set", "retries", "5")
+	require.NoError(t, err)
+
+	t.Run("Import", func(t *testing.T) {
+		bad := writeFile("bad.json", `{"retries": 0}`)
+
+		_, err := ctl("import", bad)
+		require.ErrorIs(t, err, konfig.ErrValidation)
+
+		_, err = ctl("diff", bad)
+		require.ErrorIs(t, err, konfig.ErrValidation)
+
+		_, err = ctl("import", writeFile("mode.json", `{"mode": "staging"}`))
+		require.ErrorIs(t, err, konfig.ErrValidation)
+
+		assert.Equal(t, "5\n", get("retries"))
+
+		_, err = ctl("import", writeFile("good.json", `{"retries": 2, "mode": "dev"}`))
+		require.NoError(t, err)
+		assert.Equal(t, "2\n", get("retries"))
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		// значение, записанное в обход konfigctl, не проходит правило min=1
+		broken := srv.Put(t, prefix+"/retries", 0)
+		_, err := ctl("set", "retries", "4")
+		require.NoError(t, err)
+
+		_, err = ctl("rollback", "-rev", strconv.FormatInt(broken, 10), "retries")
+		require.ErrorIs(t, err, konfig.ErrValidation)
+
+		_, err = ctl("rollback", "-rev", strconv.FormatInt(broken, 10))
+		require.ErrorIs(t, err, konfig.ErrValidation)
+
+		assert.Equal(t, "4\n", get("retries"))
+	})
+
+	t.Run("Schema tags", func(t *testing.T) {
+		sch, err := loadSchema(schemaPath)
+		require.NoError(t, err)
+
+		rtc, err := konfig.NewRealTimeConfig(context.Background(), srv.Client, prefix, sch.newConfig(),
+			konfig.WithoutSync(), konfig.WithoutWatch())
+		require.NoError(t, err)
+		for _, f := range rtc.Schema() {
+			assert.NotEmpty(t, f.Rules, f.Name)
+		}
+	})
+}
+
+func TestRun_Secrets(t *testing.T) {
+	srv := konfigtest.New(t)
+	dir := t.TempDir()
+
+	prefix := "/test/konfigctl/secrets"
+
+	schemaPath := filepath.Join(dir, "schema.json")
+	require.NoError(t, os.WriteFile(schemaPath, []byte(`{"fields": [
+		{"name": "password", "type": "string", "default": "hunter2", "flags": ["secret"]},
+		{"name": "mode", "type": "string", "default": "prod"}
+	]}`), 0o600))
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	keyPath := filepath.Join(dir, "keys.json")
+	require.NoError(t, os.WriteFile(keyPath, []byte(`{"current": "k1", "keys": {"k1": "`+key+`"}}`), 0o600))
+
+	ctl := func(args ...string) string {
+		var out bytes.Buffer
+		err := run(append([]string{"-endpoints", srv.Endpoint, "-prefix", prefix, "-schema", schemaPath, "-key-file", keyPath}, args...), &out)
+		require.NoError(t, err)
+		return out.String()
+	}
+
+	ctl("set", "password", `"s3cret"`)
+	ctl("set", "mode", `"dev"`)
+
+	// секреты выводятся только по явному -reveal
+	assert.Equal(t, "\""+konfig.RedactedValue+"\"\n", ctl("get", "password"))
+	assert.Equal(t, "\"s3cret\"\n", ctl("get", "-reveal", "password"))
+	assert.Equal(t, "\"dev\"\n", ctl("get", "mode"))
+
+	list := ctl("list")
+	assert.NotContains(t, list, "s3cret")
+	assert.Contains(t, list, konfig.RedactedValue)
+	assert.Contains(t, list, "dev")
+	assert.Contains(t, ctl("list", "-reveal"), "s3cret")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	konfig "github.com/olefire/realtime-config-go"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// knownTypes сопоставляет имена типов из файла схемы с типами Go.
+// Имена совпадают с reflect.Type.String(), чтобы схему можно было получить из сервиса.
+var knownTypes = map[string]reflect.Type{
+	"bool":                 reflect.TypeOf(false),
+	"int":                  reflect.TypeOf(0),
+	"int64":                reflect.TypeOf(int64(0)),
+	"float64":              reflect.TypeOf(float64(0)),
+	"string":               reflect.TypeOf(""),
+	"time.Duration":        reflect.TypeOf(time.Duration(0)),
+	"[]string":             reflect.TypeOf([]string{}),
+	"[]int":                reflect.TypeOf([]int{}),
+	"map[string]int":       reflect.TypeOf(map[string]int{}),
+	"map[string]string":    reflect.TypeOf(map[string]string{}),
+	"map[string]struct {}": reflect.TypeOf(map[string]struct{}{}),
+}
+
+// schemaField совпадает по формату с konfig.FieldInfo, поэтому файл схемы
+// можно получить из сервиса через RealTimeConfig.Schema
+type schemaField struct {
+	Name        string          `json:"name"`
+	Type        string          `json:"type"`
+	Default     json.RawMessage `json:"default,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Rules       []konfig.Rule   `json:"rules,omitempty"`
+	Flags       []string        `json:"flags,omitempty"`
+}
+
+func (f schemaField) secret() bool {
+	return f.hasFlag(konfig.FlagSecret)
+}
+
+func (f schemaField) hasFlag(name string) bool {
+	for _, flag := range f.Flags {
+		if flag == name {
+			return true
+		}
+	}
+	return false
+}
+
+type schemaFile struct {
+	Fields []schemaField `json:"fields"`
+}
+
+// schema описывает ключи сервиса без доступа к его типам Go
+type schema struct {
+	fields []schemaField
+	types  map[konfig.ConfigName]reflect.Type
+	rules  map[konfig.ConfigName][]konfig.Rule
+}
+
+func loadSchema(path string) (*schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read schema file: %w", err)
+	}
+
+	var f schemaFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse schema file: %w", err)
+	}
+
+	return newSchema(f.Fields)
+}
+
+func loadPublishedSchema(ctx context.Context, cli *clientv3.Client, prefix, version string) (*schema, error) {
+	ps, err := konfig.LoadSchema(ctx, cli, prefix, version)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(ps.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []schemaField
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("parse published schema: %w", err)
+	}
+
+	return newSchema(fields)
+}
+
+func newSchema(fields []schemaField) (*schema, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("schema has no fields")
+	}
+
+	s := &schema{
+		fields: fields,
+		types:  make(map[konfig.ConfigName]reflect.Type, len(fields)),
+		rules:  make(map[konfig.ConfigName][]konfig.Rule, len(fields)),
+	}
+	for _, f := range fields {
+		if f.Name == "" {
+			return nil, fmt.Errorf("schema field without name")
+		}
+		t, ok := knownTypes[f.Type]
+		if !ok {
+			return nil, fmt.Errorf("field %s has unsupported type %q", f.Name, f.Type)
+		}
+		if _, dup := s.types[konfig.ConfigName(f.Name)]; dup {
+			return nil, fmt.Errorf("duplicate field %s", f.Name)
+		}
+		s.types[konfig.ConfigName(f.Name)] = t
+		s.rules[konfig.ConfigName(f.Name)] = f.Rules
+	}
+
+	for _, f := range fields {
+		if len(f.Default) == 0 {
+			continue
+		}
+		if _, err := s.parseValue(konfig.ConfigName(f.Name), f.Default); err != nil {
+			return nil, fmt.Errorf("invalid default: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// newConfig строит указатель на структуру с etcd-тегами и значениями по умолчанию из схемы.
+// Правила полей попадают в тег validate, поэтому библиотека проверяет их при любой записи.
+func (s *schema) newConfig() any {
+	structFields := make([]reflect.StructField, 0, len(s.fields))
+	for i, f := range s.fields {
+		tag := fmt.Sprintf(`etcd:%q`, f.Name)
+		if f.secret() {
+			tag += ` secret:"true"`
+		}
+		if f.hasFlag(konfig.FlagApproval) {
+			tag += ` approval:"required"`
+		}
+		if len(f.Rules) > 0 {
+			rules := make([]string, 0, len(f.Rules))
+			for _, r := range f.Rules {
+				rules = append(rules, r.String())
+			}
+			tag += fmt.Sprintf(` validate:%q`, strings.Join(rules, ","))
+		}
+
+		structFields = append(structFields, reflect.StructField{
+			Name: fmt.Sprintf("F%d", i),
+			Type: s.types[konfig.ConfigName(f.Name)],
+			Tag:  reflect.StructTag(tag),
+		})
+	}
+
+	cfg := reflect.New(reflect.StructOf(structFields))
+	for i, f := range s.fields {
+		if len(f.Default) == 0 {
+			continue
+		}
+		def, _ := s.parseValue(konfig.ConfigName(f.Name), f.Default)
+		cfg.Elem().Field(i).Set(reflect.ValueOf(def))
+	}
+
+	return cfg.Interface()
+}
+
+// parseValue проверяет JSON-значение на соответствие типу и правилам поля
+func (s *schema) parseValue(name konfig.ConfigName, raw []byte) (any, error) {
+	t, ok := s.types[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown config field: %s", name)
+	}
+
+	val, err := decode(raw, t)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for %s (%s): %w", name, t, err)
+	}
+
+	for _, rule := range s.rules[name] {
+		if err = rule.Check(val); err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+
+	return val, nil
+}
+
+func decode(raw []byte, t reflect.Type) (any, error) {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		var str string
+		if err := json.Unmarshal(raw, &str); err == nil {
+			return time.ParseDuration(str)
+		}
+	}
+
+	ptr := reflect.New(t)
+	if err := json.Unmarshal(raw, ptr.Interface()); err != nil {
+		return nil, err
+	}
+
+	return ptr.Elem().Interface(), nil
+}
+
+func (s *schema) hasSecrets() bool {
+	for _, f := range s.fields {
+		if f.secret() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *schema) isSecret(name konfig.ConfigName) bool {
+	for _, f := range s.fields {
+		if konfig.ConfigName(f.Name) == name {
+			return f.secret()
+		}
+	}
+	return false
+}
+
+func (s *schema) names() []konfig.ConfigName {
+	names := make([]konfig.ConfigName, 0, len(s.fields))
+	for _, f := range s.fields {
+		names = append(names, konfig.ConfigName(f.Name))
+	}
+	return names
+}
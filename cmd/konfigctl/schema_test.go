@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	konfig "github.com/olefire/realtime-config-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	sch, err := newSchema([]schemaField{
+		{Name: "timeout", Type: "time.Duration"},
+		{Name: "servers", Type: "[]string"},
+		{Name: "retries", Type: "int", Rules: []konfig.Rule{{Name: "min", Arg: "1"}}},
+	})
+	require.NoError(t, err)
+
+	t.Run("Dynamic struct", func(t *testing.T) {
+		typ := reflect.TypeOf(sch.newConfig()).Elem()
+		require.Equal(t, 3, typ.NumField())
+		assert.Equal(t, "servers", typ.Field(1).Tag.Get("etcd"))
+		assert.Equal(t, reflect.TypeOf([]string{}), typ.Field(1).Type)
+	})
+
+	t.Run("Parse values", func(t *testing.T) {
+		val, err := sch.parseValue("timeout", []byte(`"1m"`))
+		require.NoError(t, err)
+		assert.Equal(t, time.Minute, val)
+
+		val, err = sch.parseValue("servers", []byte(`["a","b"]`))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "b"}, val)
+
+		_, err = sch.parseValue("retries", []byte(`"three"`))
+		assert.Error(t, err)
+
+		_, err = sch.parseValue("retries", []byte(`0`))
+		assert.Error(t, err)
+
+		_, err = sch.parseValue("unknown", []byte(`1`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid schema", func(t *testing.T) {
+		_, err := newSchema([]schemaField{{Name: "x", Type: "chan int"}})
+		assert.Error(t, err)
+
+		_, err = newSchema([]schemaField{{Name: "x", Type: "int"}, {Name: "x", Type: "string"}})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,422 @@
+package konfig
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrDeriveCycle = errors.New("derived fields form a dependency cycle")
+
+// DeriveFunc вычисляет значение производного поля по значениям его зависимостей
+type DeriveFunc func(values map[ConfigName]any) (any, error)
+
+type derivedFunc struct {
+	deps []ConfigName
+	fn   DeriveFunc
+}
+
+// derivedField поле, значение которого не хранится в etcd, а вычисляется из других полей
+type derivedField struct {
+	fieldSchema
+	Deps []ConfigName
+	fn   DeriveFunc
+}
+
+// buildDerived переносит из schema поля с тегом derive и зарегистрированные через WithDerived
+// и возвращает их вместе с порядком вычисления, в котором зависимости идут раньше зависящих полей
+func buildDerived(schema map[ConfigName]fieldSchema, registered map[ConfigName]derivedFunc) (map[ConfigName]derivedField, []ConfigName, error) {
+	derived := make(map[ConfigName]derivedField)
+	for name, meta := range schema {
+		if meta.Derive == "" {
+			continue
+		}
+		if _, ok := registered[name]; ok {
+			return nil, nil, fmt.Errorf("field %s has both derive tag and registered function", name)
+		}
+
+		e, deps, err := parseDerive(meta.Derive)
+		if err != nil {
+			return nil, nil, fmt.Errorf("field %s: %w", name, err)
+		}
+		if !isNumeric(meta.Type) {
+			return nil, nil, fmt.Errorf("field %s: derive expression requires numeric field, got %s", name, meta.Type)
+		}
+		derived[name] = derivedField{fieldSchema: meta, Deps: deps, fn: numericDerive(e, meta.Type)}
+	}
+	for name, r := range registered {
+		meta, ok := schema[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("derived field %s not found", name)
+		}
+		derived[name] = derivedField{fieldSchema: meta, Deps: r.deps, fn: r.fn}
+	}
+	for name := range derived {
+		delete(schema, name)
+	}
+
+	for name, d := range derived {
+		for _, dep := range d.Deps {
+			depMeta, ok := schema[dep]
+			if !ok {
+				if _, ok = derived[dep]; !ok {
+					return nil, nil, fmt.Errorf("field %s: unknown dependency %s", name, dep)
+				}
+				depMeta = derived[dep].fieldSchema
+			}
+			if d.Derive != "" && !isNumeric(depMeta.Type) {
+				return nil, nil, fmt.Errorf("field %s: dependency %s is not numeric", name, dep)
+			}
+		}
+	}
+
+	order, err := deriveOrder(derived)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return derived, order, nil
+}
+
+// deriveOrder сортирует производные поля топологически и находит циклы
+func deriveOrder(derived map[ConfigName]derivedField) ([]ConfigName, error) {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[ConfigName]int, len(derived))
+	order := make([]ConfigName, 0, len(derived))
+
+	var visit func(name ConfigName, path []ConfigName) error
+	visit = func(name ConfigName, path []ConfigName) error {
+		d, ok := derived[name]
+		if !ok || state[name] == done {
+			return nil
+		}
+		path = append(path, name)
+		if state[name] == visiting {
+			cycle := make([]string, 0, len(path))
+			for _, p := range path {
+				cycle = append(cycle, string(p))
+			}
+			return fmt.Errorf("%w: %s", ErrDeriveCycle, strings.Join(cycle, " -> "))
+		}
+
+		state[name] = visiting
+		for _, dep := range d.Deps {
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		order = append(order, name)
+
+		return nil
+	}
+
+	names := sortedNames(derived)
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+func sortedNames(derived map[ConfigName]derivedField) []ConfigName {
+	names := make([]ConfigName, 0, len(derived))
+	for name := range derived {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
+// derive пересчитывает в cfg производные поля, зависящие от изменившихся, в порядке зависимостей.
+// changed=nil пересчитывает все. Вызывается под rtc.mu, возвращает события об изменившихся полях.
+func (rtc *RealTimeConfig) derive(cfg reflect.Value, changed map[ConfigName]bool) []Event {
+	var events []Event
+	all := changed == nil
+	if all {
+		changed = make(map[ConfigName]bool)
+	}
+
+	for _, name := range rtc.deriveOrder {
+		d := rtc.derived[name]
+		affected := all
+		values := make(map[ConfigName]any, len(d.Deps))
+		for _, dep := range d.Deps {
+			affected = affected || changed[dep]
+			values[dep] = cfg.Field(rtc.fieldIdx(dep)).Interface()
+		}
+		if !affected {
+			continue
+		}
+
+		value, err := d.fn(values)
+		if err == nil {
+			value, err = convertType(value, d.Type)
+		}
+		if err == nil && reflect.TypeOf(value) != d.Type {
+			err = fmt.Errorf("expected %s, got %T", d.Type, value)
+		}
+		if err != nil {
+			log.Printf("Failed to derive %s: %v", name, err)
+			continue
+		}
+
+		field := cfg.Field(d.FieldIdx)
+		if reflect.DeepEqual(field.Interface(), value) {
+			continue
+		}
+		setFieldValue(field, value)
+		changed[name] = true
+		events = append(events, Event{Type: EventUpdated, Key: name, Scope: BaseScope, Value: redact(d.fieldSchema, value)})
+	}
+
+	return events
+}
+
+func (rtc *RealTimeConfig) fieldIdx(name ConfigName) int {
+	if meta, ok := rtc.schema[name]; ok {
+		return meta.FieldIdx
+	}
+	return rtc.derived[name].FieldIdx
+}
+
+func isNumeric(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch {
+	case v.CanInt():
+		return float64(v.Int())
+	case v.CanUint():
+		return float64(v.Uint())
+	default:
+		return v.Float()
+	}
+}
+
+// numericDerive вычисляет выражение тега derive в float64 и приводит результат к типу поля.
+// Целые результаты отбрасывают дробную часть, длительности участвуют в наносекундах.
+func numericDerive(e deriveExpr, t reflect.Type) DeriveFunc {
+	return func(values map[ConfigName]any) (any, error) {
+		operands := make(map[ConfigName]float64, len(values))
+		for name, v := range values {
+			operands[name] = toFloat(reflect.ValueOf(v))
+		}
+
+		f, err := e(operands)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("result %v is not a finite number", f)
+		}
+
+		out := reflect.New(t).Elem()
+		switch {
+		case out.CanInt():
+			if f >= math.MaxInt64 || f < math.MinInt64 || out.OverflowInt(int64(f)) {
+				return nil, fmt.Errorf("result %v overflows %s", f, t)
+			}
+			out.SetInt(int64(f))
+		case out.CanUint():
+			if f < 0 || f >= math.MaxUint64 || out.OverflowUint(uint64(f)) {
+				return nil, fmt.Errorf("result %v overflows %s", f, t)
+			}
+			out.SetUint(uint64(f))
+		default:
+			out.SetFloat(f)
+		}
+
+		return out.Interface(), nil
+	}
+}
+
+// deriveExpr скомпилированное выражение тега derive
+type deriveExpr func(operands map[ConfigName]float64) (float64, error)
+
+// parseDerive разбирает арифметическое выражение над именами полей и числами:
+// операции + - * /, унарный минус и скобки. Возвращает выражение и имена полей, от которых оно зависит.
+func parseDerive(s string) (deriveExpr, []ConfigName, error) {
+	p := &deriveParser{src: s}
+	p.next()
+	e, err := p.sum()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.tok != "" {
+		return nil, nil, fmt.Errorf("derive %q: unexpected %q", s, p.tok)
+	}
+	if len(p.deps) == 0 {
+		return nil, nil, fmt.Errorf("derive %q: no fields referenced", s)
+	}
+
+	return e, p.deps, nil
+}
+
+type deriveParser struct {
+	src  string
+	pos  int
+	tok  string
+	deps []ConfigName
+}
+
+// next читает следующую лексему в p.tok, пустая строка означает конец выражения
+func (p *deriveParser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos == len(p.src) {
+		p.tok = ""
+		return
+	}
+
+	start := p.pos
+	if strings.IndexByte("+-*/()", p.src[p.pos]) >= 0 {
+		p.pos++
+	} else {
+		for p.pos < len(p.src) && strings.IndexByte("+-*/() ", p.src[p.pos]) < 0 {
+			p.pos++
+		}
+	}
+	p.tok = p.src[start:p.pos]
+}
+
+func (p *deriveParser) sum() (deriveExpr, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "+" || p.tok == "-" {
+		op := p.tok
+		p.next()
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		left = binary(op, left, right)
+	}
+
+	return left, nil
+}
+
+func (p *deriveParser) product() (deriveExpr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "*" || p.tok == "/" {
+		op := p.tok
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary(op, left, right)
+	}
+
+	return left, nil
+}
+
+func (p *deriveParser) unary() (deriveExpr, error) {
+	if p.tok != "-" {
+		return p.operand()
+	}
+
+	p.next()
+	e, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return func(operands map[ConfigName]float64) (float64, error) {
+		v, err := e(operands)
+		return -v, err
+	}, nil
+}
+
+func (p *deriveParser) operand() (deriveExpr, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("derive %q: unexpected end of expression", p.src)
+	case tok == "(":
+		p.next()
+		e, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("derive %q: missing )", p.src)
+		}
+		p.next()
+		return e, nil
+	case strings.IndexByte("+*/)", tok[0]) >= 0:
+		return nil, fmt.Errorf("derive %q: unexpected %q", p.src, tok)
+	}
+	p.next()
+
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return func(map[ConfigName]float64) (float64, error) { return f, nil }, nil
+	}
+
+	name := ConfigName(tok)
+	p.deps = appendUnique(p.deps, name)
+	return func(operands map[ConfigName]float64) (float64, error) {
+		return operands[name], nil
+	}, nil
+}
+
+func binary(op string, left, right deriveExpr) deriveExpr {
+	return func(operands map[ConfigName]float64) (float64, error) {
+		l, err := left(operands)
+		if err != nil {
+			return 0, err
+		}
+		r, err := right(operands)
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		default:
+			if r == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return l / r, nil
+		}
+	}
+}
+
+func appendUnique(names []ConfigName, name ConfigName) []ConfigName {
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
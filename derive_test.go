@@ -0,0 +1,159 @@
+package konfig
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestRealTimeConfig_Derived(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/derived"
+
+	type Config struct {
+		Retries      int           `etcd:"retries"`
+		PerTry       time.Duration `etcd:"per_try_timeout"`
+		TimeoutTotal time.Duration `etcd:"timeout_total" derive:"retries * per_try_timeout"`
+		Budget       string        `etcd:"budget"`
+	}
+
+	cfg := &Config{Retries: 3, PerTry: time.Second}
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, cfg,
+		WithDerived("budget", []ConfigName{"timeout_total"}, func(values map[ConfigName]any) (any, error) {
+			return fmt.Sprintf("%v total", values["timeout_total"]), nil
+		}))
+	require.NoError(t, err)
+
+	assert.Equal(t, 3*time.Second, cfg.TimeoutTotal)
+	assert.Equal(t, "3s total", cfg.Budget)
+
+	events := rtc.Subscribe(ctx)
+
+	srv.Push(t, rtc, prefix+"/retries", 5)
+	assert.Equal(t, 5*time.Second, cfg.TimeoutTotal)
+	assert.Equal(t, "5s total", cfg.Budget)
+
+	ev := nextEvent(t, events, EventUpdated)
+	assert.Equal(t, ConfigName("retries"), ev.Key)
+	ev = nextEvent(t, events, EventUpdated)
+	assert.Equal(t, ConfigName("timeout_total"), ev.Key)
+	assert.Equal(t, 5*time.Second, ev.Value)
+	ev = nextEvent(t, events, EventUpdated)
+	assert.Equal(t, ConfigName("budget"), ev.Key)
+
+	require.NoError(t, rtc.Set(ctx, "per_try_timeout", 200*time.Millisecond))
+	assert.Equal(t, time.Second, cfg.TimeoutTotal)
+	value, err := rtc.Value("timeout_total")
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, value)
+
+	// зависимости из одной транзакции пересчитываются один раз, без промежуточных значений
+	batch := rtc.Subscribe(ctx)
+	txnResp, err := srv.Client.Txn(ctx).Then(
+		clientv3.OpPut(prefix+"/per_try_timeout", "300000000"),
+		clientv3.OpPut(prefix+"/retries", "10"),
+	).Commit()
+	require.NoError(t, err)
+	konfigtest.WaitApplied(t, rtc, txnResp.Header.Revision)
+	assert.Equal(t, 3*time.Second, cfg.TimeoutTotal)
+
+	var updated []Event
+	for len(updated) < 4 {
+		updated = append(updated, nextEvent(t, batch, EventUpdated))
+	}
+	assert.Equal(t, ConfigName("timeout_total"), updated[2].Key)
+	assert.Equal(t, 3*time.Second, updated[2].Value)
+	assert.Equal(t, ConfigName("budget"), updated[3].Key)
+	assert.Equal(t, "3s total", updated[3].Value)
+
+	// производные поля не хранятся в etcd и не записываются напрямую
+	assert.Error(t, rtc.Set(ctx, "timeout_total", time.Minute))
+	resp, err := srv.Client.Get(ctx, prefix+"/timeout_total")
+	require.NoError(t, err)
+	assert.Empty(t, resp.Kvs)
+}
+
+func TestBuildDerived(t *testing.T) {
+	ctx := context.Background()
+	client := konfigtest.New(t).Client
+
+	type Cycle struct {
+		A int `etcd:"a" derive:"b + 1"`
+		B int `etcd:"b" derive:"c * 2"`
+		C int `etcd:"c" derive:"a - 1"`
+	}
+	_, err := NewRealTimeConfig(ctx, client, "/test/config/derived/cycle", &Cycle{}, WithoutWatch())
+	require.ErrorIs(t, err, ErrDeriveCycle)
+	assert.Contains(t, err.Error(), "a -> b -> c -> a")
+
+	type SelfRegistered struct {
+		A int `etcd:"a"`
+	}
+	_, err = NewRealTimeConfig(ctx, client, "/test/config/derived/self", &SelfRegistered{}, WithoutWatch(),
+		WithDerived("a", []ConfigName{"a"}, func(map[ConfigName]any) (any, error) { return 1, nil }))
+	require.ErrorIs(t, err, ErrDeriveCycle)
+
+	type Unknown struct {
+		A int `etcd:"a" derive:"missing * 2"`
+	}
+	_, err = NewRealTimeConfig(ctx, client, "/test/config/derived/unknown", &Unknown{}, WithoutWatch())
+	assert.ErrorContains(t, err, "unknown dependency missing")
+
+	type NotNumeric struct {
+		A string `etcd:"a"`
+		B int    `etcd:"b" derive:"a + 1"`
+	}
+	_, err = NewRealTimeConfig(ctx, client, "/test/config/derived/string", &NotNumeric{}, WithoutWatch())
+	assert.ErrorContains(t, err, "dependency a is not numeric")
+}
+
+func TestParseDerive(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+		deps []ConfigName
+		err  bool
+	}{
+		{expr: "a * b", want: 6, deps: []ConfigName{"a", "b"}},
+		{expr: "a + b * 2", want: 8, deps: []ConfigName{"a", "b"}},
+		{expr: "(a + b) * 2", want: 10, deps: []ConfigName{"a", "b"}},
+		{expr: "-a + 10 / b", want: 4.0 / 3, deps: []ConfigName{"a", "b"}},
+		{expr: "a * a - 0.5", want: 3.5, deps: []ConfigName{"a"}},
+		{expr: "a *", err: true},
+		{expr: "(a + b", err: true},
+		{expr: "a b", err: true},
+		{expr: "2 * 3", err: true},
+		{expr: "", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, deps, err := parseDerive(tt.expr)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.deps, deps)
+
+			got, err := e(map[ConfigName]float64{"a": 2, "b": 3})
+			require.NoError(t, err)
+			assert.InDelta(t, tt.want, got, 1e-9)
+		})
+	}
+
+	e, _, err := parseDerive("a / b")
+	require.NoError(t, err)
+	_, err = e(map[ConfigName]float64{"a": 1, "b": 0})
+	assert.Error(t, err)
+}
@@ -0,0 +1,81 @@
+package konfig
+
+import (
+	"context"
+	"time"
+)
+
+// eventBuffer размер буфера канала подписчика
+const eventBuffer = 64
+
+// EventType тип события конфига
+type EventType string
+
+const (
+	// EventUpdated эффективное значение поля изменилось
+	EventUpdated EventType = "updated"
+	// EventCoalesced несколько изменений ключа за окно WithDebounce применены одним
+	EventCoalesced EventType = "coalesced"
+	// EventFrozen ключ меняется слишком часто и заморожен на последнем стабильном значении
+	EventFrozen EventType = "frozen"
+	// EventThawed ключ успокоился, применено его последнее значение
+	EventThawed EventType = "thawed"
+	// EventRolledBack ключ откачен после сбоя HealthProbe
+	EventRolledBack EventType = "rolled_back"
+	// EventRejected значение ключа из watch не применено, Reason причина отказа
+	EventRejected EventType = "rejected"
+	// EventInsecure значение секрета прочитано без шифрования. Такие значения допускаются
+	// только с WithPlaintextSecrets на время миграции и шифруются RotateKeys.
+	EventInsecure EventType = "insecure"
+)
+
+// Event событие конфига. Значения секретов скрыты.
+type Event struct {
+	Type  EventType  `json:"type"`
+	Key   ConfigName `json:"key"`
+	Scope string     `json:"scope"`
+	Value any        `json:"value,omitempty"`
+	// Count число изменений ключа, объединённых в EventCoalesced или пропущенных до EventThawed
+	Count int `json:"count,omitempty"`
+	// Revision ревизия, к значению которой откатился ключ, и Reason причина отката для EventRolledBack,
+	// для EventRejected ревизия и причина отказа
+	Revision int64     `json:"revision,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Subscribe возвращает канал событий конфига. Канал закрывается после отмены ctx.
+// События, которые не помещаются в буфер медленного подписчика, пропускаются.
+func (rtc *RealTimeConfig) Subscribe(ctx context.Context) <-chan Event {
+	ch := make(chan Event, eventBuffer)
+
+	rtc.subsMu.Lock()
+	rtc.subs[ch] = struct{}{}
+	rtc.subsMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		rtc.subsMu.Lock()
+		defer rtc.subsMu.Unlock()
+
+		delete(rtc.subs, ch)
+		close(ch)
+	}()
+
+	return ch
+}
+
+func (rtc *RealTimeConfig) publish(ev Event) {
+	ev.Time = time.Now().UTC()
+
+	rtc.subsMu.Lock()
+	defer rtc.subsMu.Unlock()
+
+	for ch := range rtc.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnknownFormat  = errors.New("unknown format")
+	ErrImportConflict = errors.New("config changed during import")
+)
+
+// Format формат документа для экспорта и импорта
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+)
+
+// ImportMode определяет, что делать с ключами схемы, которых нет в документе
+type ImportMode int
+
+const (
+	// ImportMerge оставляет такие ключи без изменений
+	ImportMerge ImportMode = iota
+	// ImportReplace сбрасывает такие ключи к значениям по умолчанию
+	ImportReplace
+)
+
+// ImportOptions параметры импорта
+type ImportOptions struct {
+	Format Format
+	Mode   ImportMode
+	// DryRun только вычисляет изменения, ничего не записывая
+	DryRun bool
+}
+
+// Change описывает изменение одного ключа при импорте
+type Change struct {
+	Key     ConfigName `json:"key"`
+	Old     any        `json:"old,omitempty"`
+	New     any        `json:"new"`
+	Created bool       `json:"created,omitempty"`
+}
+
+// Export записывает все ключи схемы с типизированными значениями в w.
+// Значения секретных полей заменяются на RedactedValue.
+func (rtc *RealTimeConfig) Export(ctx context.Context, w io.Writer, format Format) error {
+	current, _, err := rtc.readTyped(ctx)
+	if err != nil {
+		return err
+	}
+
+	doc := make(map[string]any, len(current))
+	for name, val := range current {
+		doc[string(name)] = redact(rtc.schema[name], val)
+	}
+
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(doc)
+	case FormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err = enc.Encode(doc); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+}
+
+// Import проверяет документ по схеме и применяет его одной транзакцией.
+// Секретные поля со значением RedactedValue пропускаются и в режиме ImportReplace не сбрасываются,
+// поэтому результат Export можно импортировать обратно.
+// Возвращает список изменений, отсортированный по имени ключа.
+func (rtc *RealTimeConfig) Import(ctx context.Context, r io.Reader, opts ImportOptions) ([]Change, error) {
+	doc, err := decodeDocument(r, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	incoming := make(map[ConfigName]any, len(doc))
+	// redacted секретные поля из документа, их значения в etcd остаются прежними и в режиме ImportReplace
+	redacted := make(map[ConfigName]bool)
+	for key, raw := range doc {
+		name := ConfigName(key)
+		meta, ok := rtc.schema[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown config field: %s", name)
+		}
+		if meta.Secret && isRedacted(raw) {
+			redacted[name] = true
+			continue
+		}
+
+		val, err := decodeValue(raw, meta.Type)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for field %s: %w", name, err)
+		}
+		if err = checkRules(name, meta, val); err != nil {
+			return nil, err
+		}
+		incoming[name] = val
+	}
+
+	if opts.Mode == ImportReplace {
+		for name, def := range rtc.defaults {
+			if _, ok := incoming[name]; !ok && !redacted[name] {
+				incoming[name] = def
+			}
+		}
+	}
+
+	current, revisions, err := rtc.readTyped(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for name, val := range incoming {
+		old, exists := current[name]
+		if exists && reflect.DeepEqual(old, val) {
+			continue
+		}
+		meta := rtc.schema[name]
+		changes = append(changes, Change{Key: name, Old: redact(meta, old), New: redact(meta, val), Created: !exists})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	proposed := make([]change, 0, len(changes))
+	for _, c := range changes {
+		proposed = append(proposed, change{name: c.Key, field: rtc.schema[c.Key], scope: BaseScope, value: incoming[c.Key], present: true})
+	}
+	if err = rtc.validateChanges(proposed); err != nil {
+		return nil, err
+	}
+
+	for _, c := range changes {
+		// предпросмотр ничего не меняет, поэтому отказ в нём не попадает в аудит
+		if err = rtc.checkAccess(ctx, c.Key, OpImport, incoming[c.Key], !opts.DryRun); err != nil {
+			return nil, err
+		}
+		if err = rtc.requireNoApproval(c.Key, OpImport); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.DryRun || len(changes) == 0 {
+		return changes, nil
+	}
+
+	audit, err := auditRecord(ctx, OpImport)
+	if err != nil {
+		return nil, err
+	}
+
+	cmps := make([]clientv3.Cmp, 0, len(changes))
+	ops := make([]clientv3.Op, 0, len(changes))
+	for _, c := range changes {
+		key := rtc.prefix + "/" + string(c.Key)
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(key), "=", revisions[c.Key]))
+
+		data, err := rtc.encodeField(ctx, rtc.schema[c.Key], incoming[c.Key])
+		if err != nil {
+			return nil, fmt.Errorf("marshal error: %w", err)
+		}
+		ops = append(ops, clientv3.OpPut(key, string(data)), clientv3.OpPut(rtc.auditKey(c.Key), audit))
+	}
+
+	txnResp, err := rtc.client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return nil, fmt.Errorf("import transaction failed: %w", err)
+	}
+	if !txnResp.Succeeded {
+		return nil, ErrImportConflict
+	}
+
+	rtc.applyChanges(proposed)
+
+	return changes, nil
+}
+
+// readTyped читает все ключи схемы из etcd вместе с их ревизиями
+func (rtc *RealTimeConfig) readTyped(ctx context.Context) (map[ConfigName]any, map[ConfigName]int64, error) {
+	resp, err := rtc.client.Get(ctx, rtc.prefix+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, nil, fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	values := make(map[ConfigName]any, len(resp.Kvs))
+	revisions := make(map[ConfigName]int64, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		name := ConfigName(strings.TrimPrefix(string(kv.Key), rtc.prefix+"/"))
+		meta, ok := rtc.schema[name]
+		if !ok {
+			continue
+		}
+
+		val, err := rtc.decodeField(ctx, meta, kv.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid value in etcd for field %s: %w", name, err)
+		}
+		values[name] = val
+		revisions[name] = kv.ModRevision
+	}
+
+	return values, revisions, nil
+}
+
+func isRedacted(raw json.RawMessage) bool {
+	var s string
+	return json.Unmarshal(raw, &s) == nil && s == RedactedValue
+}
+
+// decodeDocument разбирает документ в набор сырых JSON-значений по ключам
+func decodeDocument(r io.Reader, format Format) (map[string]json.RawMessage, error) {
+	doc := make(map[string]json.RawMessage)
+
+	switch format {
+	case FormatJSON:
+		if err := json.NewDecoder(r).Decode(&doc); err != nil {
+			return nil, fmt.Errorf("decode json: %w", err)
+		}
+	case FormatYAML:
+		// пустой или обрезанный файл не должен сбрасывать поля к значениям по умолчанию
+		var raw map[string]any
+		if err := yaml.NewDecoder(r).Decode(&raw); errors.Is(err, io.EOF) {
+			return nil, errors.New("decode yaml: empty document")
+		} else if err != nil {
+			return nil, fmt.Errorf("decode yaml: %w", err)
+		}
+		for key, val := range raw {
+			data, err := json.Marshal(val)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", key, err)
+			}
+			doc[key] = data
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,110 @@
+package konfig
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealTimeConfig_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	client := konfigtest.New(t).Client
+
+	prefix := "/test/config/export"
+
+	type Config struct {
+		Timeout time.Duration `etcd:"timeout"`
+		Servers []string      `etcd:"servers"`
+		Retries int           `etcd:"retries"`
+	}
+
+	cfg := &Config{
+		Timeout: time.Second,
+		Servers: []string{"server1"},
+		Retries: 3,
+	}
+	rtc, err := NewRealTimeConfig(ctx, client, prefix, cfg)
+	require.NoError(t, err)
+
+	t.Run("Round trip", func(t *testing.T) {
+		for _, format := range []Format{FormatJSON, FormatYAML} {
+			var buf bytes.Buffer
+			require.NoError(t, rtc.Export(ctx, &buf, format))
+
+			changes, err := rtc.Import(ctx, &buf, ImportOptions{Format: format})
+			require.NoError(t, err)
+			assert.Empty(t, changes)
+		}
+	})
+
+	t.Run("Dry run", func(t *testing.T) {
+		doc := `{"retries": 5}`
+		changes, err := rtc.Import(ctx, strings.NewReader(doc), ImportOptions{Format: FormatJSON, DryRun: true})
+		require.NoError(t, err)
+		require.Len(t, changes, 1)
+		assert.Equal(t, Change{Key: "retries", Old: 3, New: 5}, changes[0])
+
+		val, err := rtc.Get(ctx, "retries")
+		require.NoError(t, err)
+		assert.Equal(t, 3, val)
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		doc := "timeout: 5s\nservers: [server2, server3]\n"
+		changes, err := rtc.Import(ctx, strings.NewReader(doc), ImportOptions{Format: FormatYAML})
+		require.NoError(t, err)
+		assert.Len(t, changes, 2)
+
+		assert.Equal(t, 5*time.Second, cfg.Timeout)
+		assert.Equal(t, []string{"server2", "server3"}, cfg.Servers)
+		assert.Equal(t, 3, cfg.Retries)
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		doc := `{"retries": 7}`
+		_, err := rtc.Import(ctx, strings.NewReader(doc), ImportOptions{Format: FormatJSON, Mode: ImportReplace})
+		require.NoError(t, err)
+
+		val, err := rtc.Get(ctx, "timeout")
+		require.NoError(t, err)
+		assert.Equal(t, time.Second, val)
+
+		val, err = rtc.Get(ctx, "servers")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"server1"}, val)
+
+		val, err = rtc.Get(ctx, "retries")
+		require.NoError(t, err)
+		assert.Equal(t, 7, val)
+	})
+
+	t.Run("Empty document", func(t *testing.T) {
+		for _, format := range []Format{FormatJSON, FormatYAML} {
+			for _, doc := range []string{"", "\n# truncated\n"} {
+				_, err := rtc.Import(ctx, strings.NewReader(doc), ImportOptions{Format: format, Mode: ImportReplace})
+				assert.Error(t, err, format)
+			}
+		}
+
+		val, err := rtc.Get(ctx, "retries")
+		require.NoError(t, err)
+		assert.Equal(t, 7, val)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := rtc.Import(ctx, strings.NewReader(`{"unknown": 1}`), ImportOptions{Format: FormatJSON})
+		assert.Error(t, err)
+
+		_, err = rtc.Import(ctx, strings.NewReader(`{"retries": "many"}`), ImportOptions{Format: FormatJSON})
+		assert.Error(t, err)
+
+		_, err = rtc.Import(ctx, strings.NewReader(`{}`), ImportOptions{Format: "toml"})
+		assert.ErrorIs(t, err, ErrUnknownFormat)
+	})
+}
@@ -0,0 +1,115 @@
+// Package flags реализует фича-флаги поверх RealTimeConfig: значение флага -
+// набор правил, который вычисляется локально по текущему значению из watch.
+package flags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+
+	konfig "github.com/olefire/realtime-config-go"
+)
+
+var (
+	ErrNotAFlag = errors.New("config field is not a flag")
+)
+
+// Flag правила включения флага, хранятся в etcd как JSON:
+//
+//	{"enabled": true, "percentage": 10, "allow": ["user-1"], "attributes": {"country": ["ru", "kz"]}}
+//
+// Порядок вычисления: выключенный флаг всегда false; пользователь из Allow всегда получает true;
+// затем должны совпасть все Attributes; затем, если задан Percentage, пользователь
+// попадает в раскатку по хешу своего идентификатора.
+type Flag struct {
+	Enabled    bool                `json:"enabled"`
+	Percentage *float64            `json:"percentage,omitempty"`
+	Allow      []string            `json:"allow,omitempty"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+}
+
+// EvalContext описывает пользователя, для которого вычисляется флаг
+type EvalContext struct {
+	UserID     string
+	Attributes map[string]string
+}
+
+// Flags вычисляет флаги, объявленные полями конфига типа Flag или bool,
+// и назначает варианты экспериментов для полей типа Variants
+type Flags struct {
+	rtc      *konfig.RealTimeConfig
+	onAssign func(Assignment)
+}
+
+// New создаёт вычислитель флагов для конфига
+func New(rtc *konfig.RealTimeConfig, opts ...Option) *Flags {
+	f := &Flags{rtc: rtc}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Enabled сообщает, включён ли флаг для пользователя.
+// Неизвестные поля и поля другого типа считаются выключенными флагами.
+func (f *Flags) Enabled(ctx context.Context, name konfig.ConfigName, evalCtx EvalContext) bool {
+	enabled, err := f.Evaluate(ctx, name, evalCtx)
+	return err == nil && enabled
+}
+
+// Evaluate вычисляет флаг и возвращает ошибку, если поле не является флагом
+func (f *Flags) Evaluate(_ context.Context, name konfig.ConfigName, evalCtx EvalContext) (bool, error) {
+	val, err := f.rtc.Value(name)
+	if err != nil {
+		return false, err
+	}
+
+	switch v := val.(type) {
+	case bool:
+		return v, nil
+	case Flag:
+		return v.Evaluate(string(name), evalCtx), nil
+	default:
+		return false, fmt.Errorf("%w: %s has type %T", ErrNotAFlag, name, val)
+	}
+}
+
+// Evaluate вычисляет правила флага. Имя участвует в хеше, чтобы раскатки
+// разных флагов с одинаковым процентом не затрагивали одних и тех же пользователей.
+func (fl Flag) Evaluate(name string, evalCtx EvalContext) bool {
+	if !fl.Enabled {
+		return false
+	}
+
+	if evalCtx.UserID != "" && slices.Contains(fl.Allow, evalCtx.UserID) {
+		return true
+	}
+
+	for attr, allowed := range fl.Attributes {
+		val, ok := evalCtx.Attributes[attr]
+		if !ok || !slices.Contains(allowed, val) {
+			return false
+		}
+	}
+
+	if fl.Percentage != nil {
+		if evalCtx.UserID == "" {
+			return false
+		}
+		return bucket(name, evalCtx.UserID) < *fl.Percentage
+	}
+
+	return true
+}
+
+// bucket отображает пользователя в число из [0, 100) с шагом 0.01
+func bucket(name, userID string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	h.Write([]byte{':'})
+	h.Write([]byte(userID))
+
+	return float64(h.Sum64()%10000) / 100
+}
@@ -0,0 +1,89 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	konfig "github.com/olefire/realtime-config-go"
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func percentage(p float64) *float64 {
+	return &p
+}
+
+func TestFlag_Evaluate(t *testing.T) {
+	t.Run("Kill switch", func(t *testing.T) {
+		fl := Flag{Enabled: false, Allow: []string{"u1"}}
+		assert.False(t, fl.Evaluate("f", EvalContext{UserID: "u1"}))
+
+		fl = Flag{Enabled: true}
+		assert.True(t, fl.Evaluate("f", EvalContext{}))
+	})
+
+	t.Run("Allow list wins", func(t *testing.T) {
+		fl := Flag{Enabled: true, Percentage: percentage(0), Allow: []string{"u1"}}
+		assert.True(t, fl.Evaluate("f", EvalContext{UserID: "u1"}))
+		assert.False(t, fl.Evaluate("f", EvalContext{UserID: "u2"}))
+	})
+
+	t.Run("Attributes", func(t *testing.T) {
+		fl := Flag{Enabled: true, Attributes: map[string][]string{"country": {"ru", "kz"}}}
+		assert.True(t, fl.Evaluate("f", EvalContext{Attributes: map[string]string{"country": "kz"}}))
+		assert.False(t, fl.Evaluate("f", EvalContext{Attributes: map[string]string{"country": "de"}}))
+		assert.False(t, fl.Evaluate("f", EvalContext{}))
+	})
+
+	t.Run("Percentage", func(t *testing.T) {
+		fl := Flag{Enabled: true, Percentage: percentage(25)}
+
+		enabled := 0
+		for i := 0; i < 10000; i++ {
+			if fl.Evaluate("f", EvalContext{UserID: fmt.Sprintf("user-%d", i)}) {
+				enabled++
+			}
+		}
+		assert.InDelta(t, 2500, enabled, 200)
+
+		user := EvalContext{UserID: "user-42"}
+		assert.Equal(t, fl.Evaluate("f", user), fl.Evaluate("f", user))
+		assert.False(t, fl.Evaluate("f", EvalContext{}))
+	})
+}
+
+func TestFlags_Enabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+	client := srv.Client
+
+	prefix := "/test/flags"
+
+	type Config struct {
+		NewCheckout Flag   `etcd:"new_checkout"`
+		KillSwitch  bool   `etcd:"kill_switch"`
+		Mode        string `etcd:"mode"`
+	}
+
+	cfg := &Config{NewCheckout: Flag{Enabled: false}, KillSwitch: true, Mode: "dev"}
+	rtc, err := konfig.NewRealTimeConfig(ctx, client, prefix, cfg)
+	require.NoError(t, err)
+
+	ff := New(rtc)
+	user := EvalContext{UserID: "user-1"}
+
+	assert.False(t, ff.Enabled(ctx, "new_checkout", user))
+	assert.True(t, ff.Enabled(ctx, "kill_switch", user))
+	assert.False(t, ff.Enabled(ctx, "unknown", user))
+
+	_, err = ff.Evaluate(ctx, "mode", user)
+	assert.ErrorIs(t, err, ErrNotAFlag)
+
+	konfigtest.WaitApplied(t, rtc, srv.PutRaw(t, prefix+"/new_checkout", `{"enabled": true, "allow": ["user-1"], "percentage": 0}`))
+	assert.True(t, ff.Enabled(ctx, "new_checkout", user))
+	assert.False(t, ff.Enabled(ctx, "new_checkout", EvalContext{UserID: "user-2"}))
+}
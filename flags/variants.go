@@ -0,0 +1,124 @@
+package flags
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+
+	konfig "github.com/olefire/realtime-config-go"
+)
+
+var (
+	ErrNotAnExperiment = errors.New("config field is not an experiment")
+	ErrNoVariants      = errors.New("experiment has no variants with positive weight")
+)
+
+// Variants веса вариантов эксперимента, хранятся в etcd как JSON: {"control": 50, "v2": 50}
+type Variants map[string]int
+
+// Assignment событие назначения варианта, передаётся в хук аналитики
+type Assignment struct {
+	Experiment konfig.ConfigName
+	UnitID     string
+	Variant    string
+	Time       time.Time
+}
+
+// Option настраивает Flags
+type Option func(*Flags)
+
+// WithAssignmentHook задаёт хук, который вызывается при каждом назначении варианта.
+// Хук вызывается синхронно в Assign, поэтому долгую отправку стоит делать асинхронно.
+func WithAssignmentHook(hook func(Assignment)) Option {
+	return func(f *Flags) {
+		f.onAssign = hook
+	}
+}
+
+// Assign детерминированно выбирает вариант эксперимента для unitID.
+// Используется взвешенное rendezvous-хеширование: при изменении весов
+// переназначается минимально возможная доля пользователей.
+func (f *Flags) Assign(name konfig.ConfigName, unitID string) (string, error) {
+	val, err := f.rtc.Value(name)
+	if err != nil {
+		return "", err
+	}
+
+	var variants Variants
+	switch v := val.(type) {
+	case Variants:
+		variants = v
+	case map[string]int:
+		variants = v
+	default:
+		return "", fmt.Errorf("%w: %s has type %T", ErrNotAnExperiment, name, val)
+	}
+
+	variant, err := variants.Pick(string(name), unitID)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+
+	if f.onAssign != nil {
+		f.onAssign(Assignment{
+			Experiment: name,
+			UnitID:     unitID,
+			Variant:    variant,
+			Time:       time.Now(),
+		})
+	}
+
+	return variant, nil
+}
+
+// Pick выбирает вариант для unitID без вызова хуков
+func (vs Variants) Pick(experiment, unitID string) (string, error) {
+	names := make([]string, 0, len(vs))
+	for name, weight := range vs {
+		if weight > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "", ErrNoVariants
+	}
+	sort.Strings(names)
+
+	best, bestScore := "", math.Inf(-1)
+	for _, name := range names {
+		score := -float64(vs[name]) / math.Log(unitHash(experiment, unitID, name))
+		if score > bestScore {
+			best, bestScore = name, score
+		}
+	}
+
+	return best, nil
+}
+
+// unitHash отображает тройку (эксперимент, пользователь, вариант) в число из (0, 1).
+// У FNV последние байты входа плохо перемешиваются в старшие биты, а имя варианта стоит
+// в конце, поэтому сумма дополнительно проходит финализатор splitmix64.
+func unitHash(experiment, unitID, variant string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(experiment))
+	h.Write([]byte{0})
+	h.Write([]byte(unitID))
+	h.Write([]byte{0})
+	h.Write([]byte(variant))
+
+	return (float64(mix64(h.Sum64())>>11) + 0.5) / (1 << 53)
+}
+
+// mix64 финализатор splitmix64: каждый бит входа влияет на все биты результата
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
@@ -0,0 +1,131 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	konfig "github.com/olefire/realtime-config-go"
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVariants_Pick(t *testing.T) {
+	const users = 10000
+
+	assign := func(vs Variants) map[string]string {
+		result := make(map[string]string, users)
+		for i := 0; i < users; i++ {
+			unit := fmt.Sprintf("user-%d", i)
+			variant, err := vs.Pick("checkout", unit)
+			require.NoError(t, err)
+			result[unit] = variant
+		}
+		return result
+	}
+
+	moved := func(before, after map[string]string) int {
+		n := 0
+		for unit, v := range before {
+			if after[unit] != v {
+				n++
+			}
+		}
+		return n
+	}
+
+	even := assign(Variants{"control": 50, "v2": 50})
+
+	t.Run("Weights", func(t *testing.T) {
+		counts := make(map[string]int)
+		for _, v := range even {
+			counts[v]++
+		}
+		assert.InDelta(t, users/2, counts["control"], 300)
+		assert.InDelta(t, users/2, counts["v2"], 300)
+	})
+
+	t.Run("Shares", func(t *testing.T) {
+		// имена вариантов отличаются последним символом, который хеш должен перемешивать
+		cases := []Variants{
+			{"a": 1, "b": 1, "c": 1},
+			{"control": 1, "v1": 1, "v2": 1, "v3": 1},
+			{"a": 20, "b": 30, "c": 50},
+		}
+		for _, vs := range cases {
+			total := 0
+			for _, w := range vs {
+				total += w
+			}
+
+			counts := make(map[string]int)
+			for _, v := range assign(vs) {
+				counts[v]++
+			}
+			for name, w := range vs {
+				assert.InDelta(t, users*w/total, counts[name], 300, "%v: %s", vs, name)
+			}
+		}
+	})
+
+	t.Run("Minimal reassignment on weight change", func(t *testing.T) {
+		shifted := assign(Variants{"control": 60, "v2": 40})
+		assert.InDelta(t, users/10, moved(even, shifted), 300)
+	})
+
+	t.Run("New variant only takes users", func(t *testing.T) {
+		extended := assign(Variants{"control": 50, "v2": 50, "v3": 50})
+		for unit, v := range even {
+			if extended[unit] != v {
+				assert.Equal(t, "v3", extended[unit])
+			}
+		}
+	})
+
+	t.Run("No variants", func(t *testing.T) {
+		_, err := Variants{"control": 0}.Pick("checkout", "user-1")
+		assert.ErrorIs(t, err, ErrNoVariants)
+	})
+}
+
+func TestFlags_Assign(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+	client := srv.Client
+
+	prefix := "/test/flags/variants"
+
+	type Config struct {
+		Checkout Variants `etcd:"checkout"`
+		Enabled  bool     `etcd:"enabled"`
+	}
+
+	cfg := &Config{Checkout: Variants{"control": 100}}
+	rtc, err := konfig.NewRealTimeConfig(ctx, client, prefix, cfg)
+	require.NoError(t, err)
+
+	var events []Assignment
+	ff := New(rtc, WithAssignmentHook(func(a Assignment) {
+		events = append(events, a)
+	}))
+
+	variant, err := ff.Assign("checkout", "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "control", variant)
+
+	require.Len(t, events, 1)
+	assert.Equal(t, konfig.ConfigName("checkout"), events[0].Experiment)
+	assert.Equal(t, "user-1", events[0].UnitID)
+	assert.Equal(t, "control", events[0].Variant)
+
+	konfigtest.WaitApplied(t, rtc, srv.PutRaw(t, prefix+"/checkout", `{"control": 0, "v2": 100}`))
+	variant, err = ff.Assign("checkout", "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", variant)
+
+	_, err = ff.Assign("enabled", "user-1")
+	assert.ErrorIs(t, err, ErrNotAnExperiment)
+}
@@ -0,0 +1,127 @@
+package konfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// HealthProbe проверка здоровья сервиса, ошибка означает, что сервис не справляется
+type HealthProbe func(ctx context.Context) error
+
+// healthWatch наблюдение за probe после изменения ключа. prevRev ревизия последнего значения,
+// пережившего своё окно, к ней ключ откатывается при сбое.
+type healthWatch struct {
+	cancel  context.CancelFunc
+	prevRev int64
+}
+
+// watchHealth наблюдает за probe в течение окна WithHealthProbe после применения из watch
+// нового базового значения. Если за это время ключ снова изменится, наблюдение начинается
+// заново, но откат по-прежнему ведёт к значению до первого из этих изменений.
+func (rtc *RealTimeConfig) watchHealth(ctx context.Context, c change) {
+	if rtc.opts.healthProbe == nil || c.prevRev == 0 {
+		return
+	}
+
+	rtc.healthMu.Lock()
+	prevRev := c.prevRev
+	if hw := rtc.health[c.name]; hw != nil {
+		hw.cancel()
+		prevRev = hw.prevRev
+	}
+	hctx, cancel := context.WithCancel(ctx)
+	hw := &healthWatch{cancel: cancel, prevRev: prevRev}
+	rtc.health[c.name] = hw
+	rtc.healthMu.Unlock()
+
+	go func() {
+		defer func() {
+			rtc.healthMu.Lock()
+			if rtc.health[c.name] == hw {
+				delete(rtc.health, c.name)
+			}
+			rtc.healthMu.Unlock()
+			cancel()
+		}()
+
+		// откаты, в том числе автоматические, не откатываются
+		meta, err := rtc.changeMeta(hctx, c.name, c.modRev)
+		if err != nil {
+			log.Printf("Failed to read audit of %s: %v", c.name, err)
+		}
+		if meta != nil && meta.Operation == OpRollback {
+			return
+		}
+
+		if err = rtc.probeHealth(hctx); err != nil {
+			rtc.autoRollback(hctx, c, prevRev, err)
+		}
+	}()
+}
+
+// probeHealth вызывает probe до конца окна и возвращает первую ошибку.
+// Возвращает nil, если окно прошло без сбоев или наблюдение отменено.
+func (rtc *RealTimeConfig) probeHealth(ctx context.Context) error {
+	// для окна короче 10ns десятая часть равна нулю, а тикер требует положительный интервал
+	interval := max(min(time.Second, rtc.opts.healthWindow/10), time.Nanosecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(rtc.opts.healthWindow)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-deadline.C:
+			return nil
+		case <-ticker.C:
+			if err := rtc.opts.healthProbe(ctx); err != nil && ctx.Err() == nil {
+				return err
+			}
+		}
+	}
+}
+
+// autoRollback откатывает ключ к prevRev, если в etcd всё ещё значение c. Условие проверяется
+// в транзакции отката, поэтому из всех экземпляров, заметивших сбой, откат выполняет первый,
+// а более новое значение, записанное после c, не откатывается.
+func (rtc *RealTimeConfig) autoRollback(ctx context.Context, c change, prevRev int64, cause error) {
+	key := rtc.prefix + "/" + string(c.name)
+	resp, err := rtc.client.Get(ctx, key, clientv3.WithRev(prevRev))
+	if err != nil {
+		log.Printf("Failed to roll back %s: %v", c.name, err)
+		return
+	}
+	if len(resp.Kvs) == 0 {
+		log.Printf("Failed to roll back %s: %v at revision %d", c.name, ErrRevisionNotFound, prevRev)
+		return
+	}
+	val, err := rtc.decodeField(ctx, c.field, resp.Kvs[0].Value)
+	if err != nil {
+		log.Printf("Failed to roll back %s: %v", c.name, err)
+		return
+	}
+
+	reason := fmt.Sprintf("health probe failed after change at revision %d: %v", c.modRev, cause)
+	ctx = WithChangeMeta(rtc.asSystem(ctx), ChangeMeta{Author: rtc.opts.instanceID, Reason: reason})
+
+	_, err = rtc.setManyIf(ctx, map[ConfigName]any{c.name: val}, OpRollback,
+		[]clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(key), "=", c.modRev)})
+	if errors.Is(err, errTxnConflict) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to roll back %s: %v", c.name, err)
+		return
+	}
+	log.Printf("Rolled back %s to revision %d: %s", c.name, prevRev, reason)
+
+	value, _ := rtc.Value(c.name)
+	rtc.publish(Event{Type: EventRolledBack, Key: c.name, Scope: BaseScope, Value: redact(c.field, value), Revision: prevRev, Reason: reason})
+}
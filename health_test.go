@@ -0,0 +1,176 @@
+package konfig
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealTimeConfig_HealthProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/health"
+
+	type Config struct {
+		Limit int `etcd:"limit" role:"sre"`
+	}
+
+	cfg := &Config{Limit: 10}
+	var broken atomic.Bool
+	probe := func(ctx context.Context) error {
+		if broken.Load() || cfg.Limit > 100 {
+			return errors.New("error rate too high")
+		}
+		return nil
+	}
+
+	// значение, загруженное при старте, не наблюдается и сразу служит точкой отката
+	good := srv.Put(t, prefix+"/limit", 50)
+
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, cfg,
+		WithHealthProbe(probe, 300*time.Millisecond), WithInstanceID("pod-1"), WithAuthorizer(TagPolicy{}))
+	require.NoError(t, err)
+	assert.Equal(t, 50, cfg.Limit)
+
+	events := rtc.Subscribe(ctx)
+
+	t.Run("Rolled back", func(t *testing.T) {
+		srv.Push(t, rtc, prefix+"/limit", 500)
+
+		ev := nextEvent(t, events, EventRolledBack)
+		assert.Equal(t, ConfigName("limit"), ev.Key)
+		assert.Equal(t, 50, ev.Value)
+		assert.Equal(t, good, ev.Revision)
+		assert.Contains(t, ev.Reason, "error rate too high")
+		assert.Equal(t, 50, cfg.Limit)
+
+		history, err := rtc.GetKeyHistory(ctx, "limit", 0, 1)
+		require.NoError(t, err)
+		require.NotNil(t, history[0].Meta)
+		assert.Equal(t, OpRollback, history[0].Meta.Operation)
+		// автооткат разрешён на поле с ролью, хотя у экземпляра её нет
+		assert.Equal(t, "pod-1", history[0].Meta.Author)
+		assert.Equal(t, ev.Reason, history[0].Meta.Reason)
+	})
+
+	t.Run("To last value that survived", func(t *testing.T) {
+		srv.Put(t, prefix+"/limit", 60)
+		srv.Push(t, rtc, prefix+"/limit", 600)
+
+		ev := nextEvent(t, events, EventRolledBack)
+		assert.Equal(t, 50, ev.Value)
+		assert.Equal(t, 50, cfg.Limit)
+	})
+
+	t.Run("Rollbacks are not rolled back", func(t *testing.T) {
+		srv.Push(t, rtc, prefix+"/limit", 70)
+
+		// откат отменяет наблюдение за 70, а сам не наблюдается
+		history, err := rtc.GetKeyHistory(ctx, "limit", 0, 2)
+		require.NoError(t, err)
+		assert.ErrorIs(t, rtc.RollbackKeyByRevision(ctx, "limit", history[1].ModRev), ErrAccessDenied)
+		sre := WithPrincipal(ctx, Principal{Name: "oncall", Roles: []string{"sre"}})
+		require.NoError(t, rtc.RollbackKeyByRevision(sre, "limit", history[1].ModRev))
+		konfigtest.WaitApplied(t, rtc, srv.Revision(t))
+
+		broken.Store(true)
+		defer broken.Store(false)
+
+		select {
+		case ev := <-events:
+			if ev.Type == EventRolledBack {
+				t.Fatalf("unexpected rollback %+v", ev)
+			}
+		case <-time.After(500 * time.Millisecond):
+		}
+		assert.Equal(t, 50, cfg.Limit)
+	})
+}
+
+func TestRealTimeConfig_HealthRollbackConditions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/health/conditions"
+
+	type Config struct {
+		Limit int `etcd:"limit"`
+	}
+
+	good := srv.Put(t, prefix+"/limit", 50)
+
+	// окно короче 10ns не должно ломать тикер probe
+	cfg := &Config{Limit: 10}
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, cfg,
+		WithHealthProbe(func(context.Context) error { return nil }, 5*time.Nanosecond))
+	require.NoError(t, err)
+
+	bad := srv.Put(t, prefix+"/limit", 500)
+	fixed := srv.Put(t, prefix+"/limit", 80)
+	konfigtest.WaitApplied(t, rtc, fixed)
+
+	// откат по устаревшему сбою не трогает более новое значение
+	c := change{name: "limit", field: rtc.schema["limit"], scope: BaseScope, value: 500, present: true, modRev: bad}
+	rtc.autoRollback(ctx, c, good, errors.New("error rate too high"))
+
+	resp, err := srv.Client.Get(ctx, prefix+"/limit")
+	require.NoError(t, err)
+	assert.Equal(t, fixed, resp.Kvs[0].ModRevision)
+	assert.Equal(t, 80, cfg.Limit)
+
+	// повторный откат того же сбоя выполняется один раз
+	c.modRev = fixed
+	rtc.autoRollback(ctx, c, good, errors.New("error rate too high"))
+	rtc.autoRollback(ctx, c, good, errors.New("error rate too high"))
+	history, err := rtc.GetKeyHistory(ctx, "limit", 0, 0)
+	require.NoError(t, err)
+	var rollbacks int
+	for _, entry := range history {
+		if entry.Meta != nil && entry.Meta.Operation == OpRollback {
+			rollbacks++
+		}
+	}
+	assert.Equal(t, 1, rollbacks)
+	assert.Equal(t, 50, cfg.Limit)
+}
+
+func TestTenantManager_HealthProbe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/health/tenants"
+
+	type Config struct {
+		Limit int `etcd:"limit"`
+	}
+
+	m, err := NewTenantManager(ctx, srv.Client, prefix, &Config{Limit: 10}, 0,
+		WithHealthProbe(func(context.Context) error { return nil }, time.Minute))
+	require.NoError(t, err)
+	acme, err := m.ForTenant(ctx, "acme")
+	require.NoError(t, err)
+
+	konfigtest.WaitApplied(t, acme, srv.Put(t, prefix+"/limit", 20))
+	assert.Equal(t, 20, acme.Config().(*Config).Limit)
+
+	// глобальное изменение наблюдает только глобальный конфиг
+	m.global.healthMu.Lock()
+	assert.Contains(t, m.global.health, ConfigName("limit"))
+	m.global.healthMu.Unlock()
+	acme.healthMu.Lock()
+	assert.Empty(t, acme.health)
+	acme.healthMu.Unlock()
+}
@@ -0,0 +1,100 @@
+package konfig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const instancesDir = ".instances"
+
+// Instance регистрация живого экземпляра сервиса. Экземпляры с одинаковым ConfigHash
+// работают с одинаковым эффективным конфигом, значения секретов не сравниваются.
+// AppliedRevision, как и в InstanceStatus, не включает отложенные и замороженные изменения.
+type Instance struct {
+	InstanceID      string    `json:"instance_id"`
+	Hostname        string    `json:"hostname"`
+	Version         string    `json:"version,omitempty"`
+	AppliedRevision int64     `json:"applied_revision"`
+	ConfigHash      string    `json:"config_hash"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Converged сообщает, применил ли экземпляр изменения до ревизии revision включительно
+func (i Instance) Converged(revision int64) bool {
+	return i.AppliedRevision >= revision
+}
+
+// ListInstances возвращает экземпляры, зарегистрированные через WithRegistration под префиксом
+func ListInstances(ctx context.Context, cli *clientv3.Client, prefix string) ([]Instance, error) {
+	resp, err := cli.Get(ctx, prefix+"/"+instancesDir+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	instances := make([]Instance, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var instance Instance
+		if err = json.Unmarshal(kv.Value, &instance); err != nil {
+			return nil, fmt.Errorf("unmarshal failed for instance %s: %w", kv.Key, err)
+		}
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].InstanceID < instances[j].InstanceID
+	})
+
+	return instances, nil
+}
+
+// ListInstances возвращает живые экземпляры сервиса с тем же префиксом
+func (rtc *RealTimeConfig) ListInstances(ctx context.Context) ([]Instance, error) {
+	return ListInstances(ctx, rtc.client, rtc.prefix)
+}
+
+// instance собирает регистрацию этого экземпляра
+func (rtc *RealTimeConfig) instance() (Instance, error) {
+	hash, err := rtc.configHash()
+	if err != nil {
+		return Instance{}, err
+	}
+	hostname, _ := os.Hostname()
+
+	return Instance{
+		InstanceID:      rtc.opts.instanceID,
+		Hostname:        hostname,
+		Version:         rtc.opts.buildVersion,
+		AppliedRevision: rtc.settledRevision(),
+		ConfigHash:      hash,
+		UpdatedAt:       time.Now().UTC(),
+	}, nil
+}
+
+// configHash хеш эффективных значений всех полей конфига. Хеш публикуется в .instances,
+// поэтому секреты в него не входят: иначе по нему можно было бы перебрать их значения.
+// Значения собираются под одной блокировкой, чтобы хеш соответствовал одному состоянию.
+func (rtc *RealTimeConfig) configHash() (string, error) {
+	rtc.mu.RLock()
+	cfgValue := reflect.ValueOf(rtc.cfg).Elem()
+	values := make(map[ConfigName]any, len(rtc.schema))
+	for name, meta := range rtc.schema {
+		values[name] = redact(meta, cfgValue.Field(meta.FieldIdx).Interface())
+	}
+	// ключи map кодируются в отсортированном порядке
+	data, err := json.Marshal(values)
+	rtc.mu.RUnlock()
+	if err != nil {
+		return "", fmt.Errorf("marshal error: %w", err)
+	}
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
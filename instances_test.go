@@ -0,0 +1,108 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealTimeConfig_ListInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/instances"
+
+	type Config struct {
+		Mode string `etcd:"mode"`
+	}
+
+	rtc1, err := NewRealTimeConfig(ctx, srv.Client, prefix, &Config{Mode: "prod"},
+		WithInstanceID("node-1"), WithRegistration("v1.2.0", 5*time.Second))
+	require.NoError(t, err)
+
+	ctx2, stop2 := context.WithCancel(ctx)
+	rtc2, err := NewRealTimeConfig(ctx2, srv.Client, prefix, &Config{Mode: "prod"},
+		WithInstanceID("node-2"), WithRegistration("v1.3.0", 5*time.Second), WithOverrideScopes("canary"))
+	require.NoError(t, err)
+
+	rev := srv.Put(t, prefix+"/mode", "shadow")
+	konfigtest.WaitApplied(t, rtc1, rev)
+	konfigtest.WaitApplied(t, rtc2, rev)
+
+	dir := prefix + "/" + instancesDir + "/"
+	srv.Await(t, dir, func(kvs map[string][]byte) bool {
+		converged := 0
+		for _, data := range kvs {
+			var i Instance
+			if json.Unmarshal(data, &i) == nil && i.Converged(rev) {
+				converged++
+			}
+		}
+		return converged == 2
+	})
+	instances, err := rtc1.ListInstances(ctx)
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	assert.Equal(t, "node-1", instances[0].InstanceID)
+	assert.Equal(t, "v1.2.0", instances[0].Version)
+	assert.NotEmpty(t, instances[0].Hostname)
+	assert.Equal(t, "v1.3.0", instances[1].Version)
+	assert.Equal(t, instances[0].ConfigHash, instances[1].ConfigHash)
+
+	// переопределение меняет эффективный конфиг только одного экземпляра
+	require.NoError(t, rtc2.SetOverride(ctx, "canary", "mode", "broken"))
+	srv.Await(t, dir, func(kvs map[string][]byte) bool {
+		var i1, i2 Instance
+		return json.Unmarshal(kvs[dir+"node-1"], &i1) == nil && json.Unmarshal(kvs[dir+"node-2"], &i2) == nil &&
+			i1.ConfigHash != i2.ConfigHash
+	})
+
+	stop2()
+	srv.Await(t, dir, func(kvs map[string][]byte) bool {
+		return len(kvs) == 1
+	})
+	instances, err = ListInstances(ctx, srv.Client, prefix)
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, "node-1", instances[0].InstanceID)
+}
+
+func TestRealTimeConfig_ConfigHashSecrets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/instances/secrets"
+
+	type Config struct {
+		Password string `etcd:"password" secret:"true"`
+		Mode     string `etcd:"mode"`
+	}
+
+	kp, err := NewFileKeyProvider(writeKeyFile(t, "k1", "k1"))
+	require.NoError(t, err)
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, &Config{Password: "1234", Mode: "prod"}, WithKeyProvider(kp))
+	require.NoError(t, err)
+
+	hash, err := rtc.configHash()
+	require.NoError(t, err)
+
+	// по опубликованному хешу нельзя перебрать значение секрета
+	require.NoError(t, rtc.Set(ctx, "password", "5678"))
+	same, err := rtc.configHash()
+	require.NoError(t, err)
+	assert.Equal(t, hash, same)
+
+	require.NoError(t, rtc.Set(ctx, "mode", "dev"))
+	changed, err := rtc.configHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, changed)
+}
@@ -0,0 +1,262 @@
+package konfigtest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Faults клиент etcd, через который тест управляет сбоями: обрывает watch,
+// сообщает о компактизации, задерживает, дублирует и переставляет события,
+// проваливает транзакции. Передайте Faults.Client в konfig вместо Server.Client:
+//
+//	f := srv.Faults(t)
+//	rtc, err := konfig.NewRealTimeConfig(ctx, f.Client, "/app", cfg)
+//	f.DropWatches()
+//	srv.Push(t, rtc, "/app/timeout", 45)
+type Faults struct {
+	Client *clientv3.Client
+
+	server *Server
+
+	mu          sync.Mutex
+	streams     map[*faultStream]struct{}
+	delay       time.Duration
+	duplicate   bool
+	reorder     bool
+	txnFailures int
+}
+
+// Faults возвращает клиент со сбоями поверх сервера. Без включённых сбоев он ведёт себя как Server.Client.
+func (s *Server) Faults(t testing.TB) *Faults {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// Close у клиента закрыл бы Lease и Watcher, общие с Server.Client, поэтому останавливаем только контекст
+	t.Cleanup(cancel)
+
+	f := &Faults{server: s, streams: make(map[*faultStream]struct{})}
+
+	cli := clientv3.NewCtxClient(ctx)
+	cli.Cluster = s.Client.Cluster
+	cli.KV = &faultKV{KV: s.Client.KV, f: f}
+	cli.Lease = s.Client.Lease
+	cli.Watcher = &faultWatcher{Watcher: s.Client.Watcher, f: f}
+	cli.Auth = s.Client.Auth
+	cli.Maintenance = s.Client.Maintenance
+	f.Client = cli
+
+	return f
+}
+
+// DropWatches закрывает все открытые watch, как при обрыве соединения с etcd
+func (f *Faults) DropWatches() {
+	f.stopStreams(nil)
+}
+
+// Compact компактизирует историю etcd до текущей ревизии и завершает открытые watch
+// с ErrCompacted. Возвращает ревизию компактизации.
+func (f *Faults) Compact(t testing.TB) int64 {
+	t.Helper()
+
+	rev := f.server.Revision(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	if _, err := f.server.Client.Compact(ctx, rev, clientv3.WithCompactPhysical()); err != nil {
+		t.Fatalf("konfigtest: compact at %d: %v", rev, err)
+	}
+
+	f.stopStreams(&clientv3.WatchResponse{CompactRevision: rev, Canceled: true})
+	return rev
+}
+
+// SetDelay задерживает доставку каждого ответа watch на d. Ноль отключает задержку.
+func (f *Faults) SetDelay(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.delay = d
+}
+
+// SetDuplicate включает повторную доставку каждого ответа watch
+func (f *Faults) SetDuplicate(on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.duplicate = on
+}
+
+// SetReorder включает доставку событий внутри ответа watch в обратном порядке ревизий
+func (f *Faults) SetReorder(on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reorder = on
+}
+
+// FailTxn проваливает n следующих транзакций: они не выполняются и возвращают Succeeded=false,
+// как при конфликте с параллельной записью
+func (f *Faults) FailTxn(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.txnFailures = n
+}
+
+// Watches возвращает число открытых watch
+func (f *Faults) Watches() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.streams)
+}
+
+func (f *Faults) stopStreams(final *clientv3.WatchResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for st := range f.streams {
+		select {
+		case st.stop <- final:
+		default:
+		}
+	}
+}
+
+func (f *Faults) takeTxnFailure() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.txnFailures == 0 {
+		return false
+	}
+	f.txnFailures--
+	return true
+}
+
+type faultKV struct {
+	clientv3.KV
+	f *Faults
+}
+
+func (kv *faultKV) Txn(ctx context.Context) clientv3.Txn {
+	return &faultTxn{Txn: kv.KV.Txn(ctx), f: kv.f}
+}
+
+type faultTxn struct {
+	clientv3.Txn
+	f *Faults
+}
+
+func (t *faultTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.Txn = t.Txn.If(cs...)
+	return t
+}
+
+func (t *faultTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.Txn = t.Txn.Then(ops...)
+	return t
+}
+
+func (t *faultTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	t.Txn = t.Txn.Else(ops...)
+	return t
+}
+
+func (t *faultTxn) Commit() (*clientv3.TxnResponse, error) {
+	if t.f.takeTxnFailure() {
+		return &clientv3.TxnResponse{Header: &etcdserverpb.ResponseHeader{}}, nil
+	}
+	return t.Txn.Commit()
+}
+
+type faultWatcher struct {
+	clientv3.Watcher
+	f *Faults
+}
+
+// faultStream открытый watch; в stop передаётся последний ответ перед закрытием потока или nil
+type faultStream struct {
+	stop chan *clientv3.WatchResponse
+}
+
+func (w *faultWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	wctx, cancel := context.WithCancel(ctx)
+	in := w.Watcher.Watch(wctx, key, opts...)
+	out := make(chan clientv3.WatchResponse)
+
+	st := &faultStream{stop: make(chan *clientv3.WatchResponse, 1)}
+	w.f.mu.Lock()
+	w.f.streams[st] = struct{}{}
+	w.f.mu.Unlock()
+
+	go func() {
+		defer close(out)
+		defer cancel()
+		defer func() {
+			w.f.mu.Lock()
+			delete(w.f.streams, st)
+			w.f.mu.Unlock()
+		}()
+
+		send := func(wr clientv3.WatchResponse) bool {
+			select {
+			case out <- wr:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		finish := func(final *clientv3.WatchResponse) {
+			if final != nil {
+				send(*final)
+			}
+		}
+
+		for {
+			select {
+			case final := <-st.stop:
+				finish(final)
+				return
+			case wr, ok := <-in:
+				if !ok {
+					return
+				}
+
+				w.f.mu.Lock()
+				delay, duplicate, reorder := w.f.delay, w.f.duplicate, w.f.reorder
+				w.f.mu.Unlock()
+
+				if delay > 0 {
+					select {
+					case <-time.After(delay):
+					case final := <-st.stop:
+						finish(final)
+						return
+					case <-ctx.Done():
+						return
+					}
+				}
+				if reorder {
+					events := make([]*clientv3.Event, len(wr.Events))
+					for i, ev := range wr.Events {
+						events[len(events)-1-i] = ev
+					}
+					wr.Events = events
+				}
+
+				if !send(wr) || duplicate && !send(wr) {
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,212 @@
+// Package konfigtest запускает встроенный etcd для тестов konfig и сервисов, которые его используют:
+//
+//	srv := konfigtest.New(t)
+//	rtc, err := konfig.NewRealTimeConfig(ctx, srv.Client, "/app", cfg)
+//	srv.Push(t, rtc, "/app/timeout", 45)
+//
+// Каждый сервер работает во временном каталоге на свободном порту и останавливается
+// по завершении теста. Пакет не импортирует konfig, поэтому его можно использовать
+// и во внутренних тестах самой библиотеки.
+package konfigtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// DefaultTimeout сколько хелперы ждут применения изменений
+const DefaultTimeout = 5 * time.Second
+
+// Applier конфиг, применение изменений которого можно дождаться. Реализуется *konfig.RealTimeConfig.
+type Applier interface {
+	WaitApplied(ctx context.Context, revision int64) error
+}
+
+// Server встроенный etcd с подключённым клиентом
+type Server struct {
+	Client   *clientv3.Client
+	Endpoint string
+
+	etcd *embed.Etcd
+}
+
+// New запускает etcd и регистрирует его остановку в t.Cleanup
+func New(t testing.TB) *Server {
+	t.Helper()
+
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+
+	clientURL := freeURL(t)
+	peerURL := freeURL(t)
+	cfg.ListenClientUrls = []url.URL{clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{clientURL}
+	cfg.ListenPeerUrls = []url.URL{peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("konfigtest: start etcd: %v", err)
+	}
+	t.Cleanup(e.Close)
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(DefaultTimeout):
+		e.Server.Stop()
+		t.Fatal("konfigtest: etcd did not become ready")
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{clientURL.Host},
+		DialTimeout: DefaultTimeout,
+	})
+	if err != nil {
+		t.Fatalf("konfigtest: connect to etcd: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return &Server{Client: client, Endpoint: clientURL.Host, etcd: e}
+}
+
+// Put записывает value в JSON, как его кодирует konfig, и возвращает ревизию записи
+func (s *Server) Put(t testing.TB, key string, value any) int64 {
+	t.Helper()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("konfigtest: marshal %s: %v", key, err)
+	}
+
+	return s.PutRaw(t, key, string(data))
+}
+
+// PutRaw записывает значение как есть и возвращает ревизию записи
+func (s *Server) PutRaw(t testing.TB, key, value string) int64 {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	resp, err := s.Client.Put(ctx, key, value)
+	if err != nil {
+		t.Fatalf("konfigtest: put %s: %v", key, err)
+	}
+
+	return resp.Header.Revision
+}
+
+// Delete удаляет ключ и возвращает ревизию удаления
+func (s *Server) Delete(t testing.TB, key string) int64 {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	resp, err := s.Client.Delete(ctx, key)
+	if err != nil {
+		t.Fatalf("konfigtest: delete %s: %v", key, err)
+	}
+
+	return resp.Header.Revision
+}
+
+// Push записывает value и ждёт, пока cfg применит изменение
+func (s *Server) Push(t testing.TB, cfg Applier, key string, value any) {
+	t.Helper()
+
+	WaitApplied(t, cfg, s.Put(t, key, value))
+}
+
+// Sync ждёт, пока cfg применит всё, что записано в etcd к этому моменту. Подходит после
+// методов konfig, которые не возвращают ревизию, например SetOverride.
+func (s *Server) Sync(t testing.TB, cfg Applier) {
+	t.Helper()
+
+	WaitApplied(t, cfg, s.Revision(t))
+}
+
+// Revision возвращает текущую ревизию etcd
+func (s *Server) Revision(t testing.TB) int64 {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	resp, err := s.Client.Get(ctx, "\x00", clientv3.WithCountOnly())
+	if err != nil {
+		t.Fatalf("konfigtest: get revision: %v", err)
+	}
+
+	return resp.Header.Revision
+}
+
+// Await ждёт, пока ключи под префиксом prefix удовлетворят cond, но не дольше DefaultTimeout.
+// cond получает значения ключей по полному имени и вызывается сразу и после каждого изменения
+// под префиксом, поэтому ожидание не зависит от интервала опроса. Подходит для ключей,
+// которые konfig пишет сам или удаляет по истечении lease: статусов, регистраций, заявок.
+func (s *Server) Await(t testing.TB, prefix string, cond func(kvs map[string][]byte) bool) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	resp, err := s.Client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatalf("konfigtest: get %s: %v", prefix, err)
+	}
+	kvs := make(map[string][]byte, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs[string(kv.Key)] = kv.Value
+	}
+
+	wch := s.Client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+	for !cond(kvs) {
+		wr, ok := <-wch
+		if !ok || wr.Err() != nil {
+			t.Fatalf("konfigtest: %s did not reach expected state: %v", prefix, errors.Join(ctx.Err(), wr.Err()))
+		}
+		for _, ev := range wr.Events {
+			if ev.Type == clientv3.EventTypeDelete {
+				delete(kvs, string(ev.Kv.Key))
+				continue
+			}
+			kvs[string(ev.Kv.Key)] = ev.Kv.Value
+		}
+	}
+}
+
+// WaitApplied ждёт, пока cfg применит изменения до ревизии revision, но не дольше DefaultTimeout
+func WaitApplied(t testing.TB, cfg Applier, revision int64) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	if err := cfg.WaitApplied(ctx, revision); err != nil {
+		t.Fatalf("konfigtest: %v", err)
+	}
+}
+
+func freeURL(t testing.TB) url.URL {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("konfigtest: find free port: %v", err)
+	}
+	defer l.Close()
+
+	return url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", l.Addr().(*net.TCPAddr).Port)}
+}
@@ -0,0 +1,215 @@
+package konfig
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// keyLimit состояние ограничения частоты изменений одного ключа etcd
+type keyLimit struct {
+	// changes время изменений за окно WithFlapProtection
+	changes []time.Time
+	frozen  bool
+	// group отложенные изменения, в которые входит ключ
+	group *limitGroup
+}
+
+// limitGroup отложенные изменения ключей. Транзакция etcd не разрывается: её изменения
+// и изменения транзакций с общими ключами откладываются, замораживаются и применяются вместе.
+type limitGroup struct {
+	ctx context.Context
+	// changes последние изменения ключей группы, prevRev в них от первого неприменённого
+	changes []change
+	// pending число полученных, но ещё не применённых изменений по ключам
+	pending map[string]int
+	// firstRev ревизия первого неприменённого изменения группы
+	firstRev int64
+	frozen   bool
+
+	timer *time.Timer
+	// seq отличает текущий таймер от остановленных, которые уже успели сработать
+	seq int
+}
+
+// add добавляет в группу n изменений ключа, последнее из которых c
+func (g *limitGroup) add(c change, n int) {
+	g.pending[c.key] += n
+	if g.firstRev == 0 || c.modRev < g.firstRev {
+		g.firstRev = c.modRev
+	}
+	for i, p := range g.changes {
+		if p.key == c.key {
+			c.prevRev = p.prevRev
+			g.changes[i] = c
+			return
+		}
+	}
+	g.changes = append(g.changes, c)
+}
+
+// limitEvents применяет изменения одной транзакции из watch с учётом WithDebounce и WithFlapProtection.
+// Если мигает хотя бы один ключ транзакции, замораживается вся её группа.
+func (rtc *RealTimeConfig) limitEvents(ctx context.Context, changes []change) {
+	rtc.limitMu.Lock()
+	defer rtc.limitMu.Unlock()
+
+	g := &limitGroup{ctx: ctx, pending: make(map[string]int)}
+	for _, c := range changes {
+		l := rtc.limits[c.key]
+		if l == nil {
+			l = &keyLimit{}
+			rtc.limits[c.key] = l
+		}
+		if l.group != nil && l.group != g {
+			rtc.mergeLimit(g, l.group)
+		}
+	}
+	for _, c := range changes {
+		g.add(c, 1)
+		rtc.limits[c.key].group = g
+	}
+
+	if rtc.opts.flapChanges > 0 {
+		now := time.Now()
+		for _, c := range changes {
+			l := rtc.limits[c.key]
+			recent := l.changes[:0]
+			for _, at := range l.changes {
+				if now.Sub(at) < rtc.opts.flapWindow {
+					recent = append(recent, at)
+				}
+			}
+			l.changes = append(recent, now)
+			g.frozen = g.frozen || len(l.changes) > rtc.opts.flapChanges
+		}
+	}
+
+	switch {
+	case g.frozen:
+		rtc.freeze(g)
+		rtc.restartLimit(g, rtc.opts.flapWindow, rtc.thaw)
+	case rtc.opts.debounce > 0:
+		rtc.restartLimit(g, rtc.opts.debounce, rtc.flush)
+	default:
+		rtc.releaseLimit(g)
+		rtc.applyEvents(ctx, g.changes)
+	}
+}
+
+// mergeLimit переносит в g отложенные изменения old и останавливает таймер old
+func (rtc *RealTimeConfig) mergeLimit(g, old *limitGroup) {
+	if old.timer != nil {
+		old.timer.Stop()
+	}
+	old.seq++
+
+	for _, c := range old.changes {
+		g.add(c, old.pending[c.key])
+		rtc.limits[c.key].group = g
+	}
+	g.frozen = g.frozen || old.frozen
+	g.firstRev = min(g.firstRev, old.firstRev)
+}
+
+// freeze сообщает о заморозке ключей группы, которые ещё не были заморожены
+func (rtc *RealTimeConfig) freeze(g *limitGroup) {
+	for _, c := range g.changes {
+		l := rtc.limits[c.key]
+		if l.frozen {
+			continue
+		}
+		l.frozen = true
+
+		if len(l.changes) > rtc.opts.flapChanges {
+			log.Printf("Key %s is flapping (%d changes in %v), frozen at last stable value", c.key, len(l.changes), rtc.opts.flapWindow)
+		} else {
+			log.Printf("Key %s is frozen at last stable value with flapping keys of its transaction", c.key)
+		}
+		stable, _ := rtc.layerValue(c.name, c.scope)
+		rtc.publish(Event{Type: EventFrozen, Key: c.name, Scope: c.scope, Value: redact(c.field, stable), Count: len(l.changes)})
+	}
+}
+
+// restartLimit перезапускает таймер группы: fn сработает, если её ключи простоят без изменений d
+func (rtc *RealTimeConfig) restartLimit(g *limitGroup, d time.Duration, fn func(g *limitGroup, seq int)) {
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	g.seq++
+	seq := g.seq
+	g.timer = time.AfterFunc(d, func() { fn(g, seq) })
+}
+
+// releaseLimit отвязывает ключи от группы перед её применением
+func (rtc *RealTimeConfig) releaseLimit(g *limitGroup) {
+	g.seq++
+	for _, c := range g.changes {
+		if l := rtc.limits[c.key]; l.group == g {
+			l.group = nil
+		}
+	}
+}
+
+// flush применяет последние из отложенных WithDebounce изменений группы. Группа применяется
+// под limitMu, чтобы pendingRevision не упустил изменения, которые уже отвязаны, но ещё не применены.
+func (rtc *RealTimeConfig) flush(g *limitGroup, seq int) {
+	rtc.limitMu.Lock()
+	defer rtc.limitMu.Unlock()
+
+	if g.seq != seq || g.frozen {
+		return
+	}
+	rtc.releaseLimit(g)
+	defer rtc.statusChanged()
+
+	if !rtc.applyEvents(g.ctx, g.changes) {
+		return
+	}
+	for _, c := range g.changes {
+		if count := g.pending[c.key]; count > 1 {
+			rtc.publish(Event{Type: EventCoalesced, Key: c.name, Scope: c.scope, Value: redact(c.field, c.value), Count: count})
+		}
+	}
+}
+
+// thaw размораживает успокоившуюся группу и применяет последние значения её ключей
+func (rtc *RealTimeConfig) thaw(g *limitGroup, seq int) {
+	rtc.limitMu.Lock()
+	defer rtc.limitMu.Unlock()
+
+	if g.seq != seq || !g.frozen {
+		return
+	}
+	rtc.releaseLimit(g)
+	defer rtc.statusChanged()
+
+	for _, c := range g.changes {
+		l := rtc.limits[c.key]
+		l.frozen = false
+		l.changes = nil
+		log.Printf("Key %s is stable again, applying latest value", c.key)
+	}
+
+	if !rtc.applyEvents(g.ctx, g.changes) {
+		return
+	}
+	for _, c := range g.changes {
+		rtc.publish(Event{Type: EventThawed, Key: c.name, Scope: c.scope, Value: redact(c.field, c.value), Count: g.pending[c.key]})
+	}
+}
+
+// pendingRevision возвращает ревизию первого отложенного или замороженного изменения, 0 если их нет
+func (rtc *RealTimeConfig) pendingRevision() int64 {
+	rtc.limitMu.Lock()
+	defer rtc.limitMu.Unlock()
+
+	var rev int64
+	for _, l := range rtc.limits {
+		if l.group != nil && (rev == 0 || l.group.firstRev < rev) {
+			rev = l.group.firstRev
+		}
+	}
+
+	return rev
+}
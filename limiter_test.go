@@ -0,0 +1,208 @@
+package konfig
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func nextEvent(t *testing.T, events <-chan Event, typ EventType) Event {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == typ {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", typ)
+		}
+	}
+}
+
+func TestRealTimeConfig_Debounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/debounce"
+
+	type Config struct {
+		Value int `etcd:"value"`
+	}
+
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, &Config{}, WithDebounce(200*time.Millisecond))
+	require.NoError(t, err)
+
+	events := rtc.Subscribe(ctx)
+
+	var rev int64
+	for i := 1; i <= 5; i++ {
+		rev = srv.Put(t, prefix+"/value", i)
+	}
+	konfigtest.WaitApplied(t, rtc, rev)
+
+	// промежуточные значения не применяются: первое обновление уже несёт последнее
+	updated := nextEvent(t, events, EventUpdated)
+	assert.Equal(t, ConfigName("value"), updated.Key)
+	assert.Equal(t, 5, updated.Value)
+	v, err := rtc.Value("value")
+	require.NoError(t, err)
+	assert.Equal(t, 5, v)
+
+	coalesced := nextEvent(t, events, EventCoalesced)
+	assert.Equal(t, 5, coalesced.Count)
+	assert.Equal(t, 5, coalesced.Value)
+
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event %+v", ev)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestRealTimeConfig_FlapProtection(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/flap"
+
+	type Config struct {
+		Value int    `etcd:"value"`
+		Mode  string `etcd:"mode"`
+	}
+
+	cfg := &Config{Mode: "prod"}
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, cfg, WithFlapProtection(3, time.Second))
+	require.NoError(t, err)
+
+	events := rtc.Subscribe(ctx)
+
+	for i := 1; i <= 3; i++ {
+		srv.Push(t, rtc, prefix+"/value", i)
+	}
+	assert.Equal(t, 3, cfg.Value)
+
+	srv.Push(t, rtc, prefix+"/value", 4)
+	srv.Push(t, rtc, prefix+"/value", 5)
+	assert.Equal(t, 3, cfg.Value)
+
+	frozen := nextEvent(t, events, EventFrozen)
+	assert.Equal(t, ConfigName("value"), frozen.Key)
+	assert.Equal(t, 3, frozen.Value)
+
+	// другие ключи не затронуты
+	srv.Push(t, rtc, prefix+"/mode", "canary")
+	assert.Equal(t, "canary", cfg.Mode)
+
+	thawed := nextEvent(t, events, EventThawed)
+	assert.Equal(t, 5, thawed.Value)
+	assert.Equal(t, 2, thawed.Count)
+	assert.Equal(t, 5, cfg.Value)
+
+	srv.Push(t, rtc, prefix+"/value", 6)
+	assert.Equal(t, 6, cfg.Value)
+}
+
+func TestRealTimeConfig_LimitTransactions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/flap/txn"
+
+	type Config struct {
+		MinPool int `etcd:"min_pool"`
+		MaxPool int `etcd:"max_pool"`
+	}
+
+	cfg := &Config{MinPool: 1, MaxPool: 5}
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, cfg,
+		WithDebounce(100*time.Millisecond), WithFlapProtection(3, time.Second))
+	require.NoError(t, err)
+
+	events := rtc.Subscribe(ctx)
+
+	// cfg меняется из таймеров, поэтому читаем его через Value
+	pools := func() [2]any {
+		minPool, err := rtc.Value("min_pool")
+		require.NoError(t, err)
+		maxPool, err := rtc.Value("max_pool")
+		require.NoError(t, err)
+		return [2]any{minPool, maxPool}
+	}
+
+	put := func(values map[string]string) int64 {
+		ops := make([]clientv3.Op, 0, len(values))
+		for key, value := range values {
+			ops = append(ops, clientv3.OpPut(prefix+"/"+key, value))
+		}
+		resp, err := srv.Client.Txn(ctx).Then(ops...).Commit()
+		require.NoError(t, err)
+		return resp.Header.Revision
+	}
+
+	// транзакция откладывается целиком, даже если её ключ меняется снова
+	put(map[string]string{"min_pool": "2", "max_pool": "10"})
+	konfigtest.WaitApplied(t, rtc, put(map[string]string{"max_pool": "20"}))
+	assert.Equal(t, [2]any{1, 5}, pools())
+
+	nextEvent(t, events, EventUpdated)
+	assert.Equal(t, [2]any{2, 20}, pools())
+	coalesced := nextEvent(t, events, EventCoalesced)
+	assert.Equal(t, ConfigName("max_pool"), coalesced.Key)
+	assert.Equal(t, 2, coalesced.Count)
+
+	// мигающий max_pool замораживает и min_pool из той же транзакции
+	put(map[string]string{"max_pool": "30"})
+	konfigtest.WaitApplied(t, rtc, put(map[string]string{"min_pool": "3", "max_pool": "40"}))
+
+	frozen := map[ConfigName]Event{}
+	for len(frozen) < 2 {
+		ev := nextEvent(t, events, EventFrozen)
+		frozen[ev.Key] = ev
+	}
+	assert.Equal(t, 2, frozen["min_pool"].Value)
+	assert.Equal(t, 20, frozen["max_pool"].Value)
+	assert.Equal(t, [2]any{2, 20}, pools())
+
+	thawed := nextEvent(t, events, EventThawed)
+	assert.Equal(t, [2]any{3, 40}, pools())
+	assert.Contains(t, []ConfigName{"min_pool", "max_pool"}, thawed.Key)
+}
+
+func TestRealTimeConfig_Subscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	type Config struct {
+		Value int `etcd:"value"`
+	}
+
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, "/test/config/subscribe", &Config{})
+	require.NoError(t, err)
+
+	subCtx, unsubscribe := context.WithCancel(ctx)
+	events := rtc.Subscribe(subCtx)
+
+	require.NoError(t, rtc.Set(ctx, "value", 7))
+	ev := nextEvent(t, events, EventUpdated)
+	assert.Equal(t, Event{Type: EventUpdated, Key: "value", Scope: BaseScope, Value: 7, Time: ev.Time}, ev)
+
+	unsubscribe()
+	for range events {
+	}
+}
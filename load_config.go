@@ -3,6 +3,7 @@ package konfig
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
@@ -12,16 +13,26 @@ import (
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
-// syncWithDefaults синхронизирует etcd со значениями по умолчанию из структуры
-func (rtc *RealTimeConfig) syncWithDefaults(ctx context.Context) error {
-	current, err := rtc.getCurrentValues(ctx)
-	if err != nil {
-		return err
-	}
+// syncRetries сколько раз синхронизация перечитывает etcd после конфликта транзакции
+const syncRetries = 5
 
-	defaults := rtc.getDefaultValues()
+var errSyncConflict = errors.New("sync transaction conflict")
+
+// syncWithDefaults синхронизирует etcd со значениями по умолчанию из структуры.
+// Если другой экземпляр успел записать значения между чтением и транзакцией, синхронизация повторяется.
+func (rtc *RealTimeConfig) syncWithDefaults(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		current, err := rtc.getCurrentValues(ctx)
+		if err != nil {
+			return err
+		}
 
-	return rtc.applySync(ctx, defaults, current)
+		err = rtc.applySync(ctx, rtc.defaults, current)
+		if !errors.Is(err, errSyncConflict) || attempt == syncRetries {
+			return err
+		}
+		log.Printf("Sync of %s conflicted, retrying (attempt %d)", rtc.prefix, attempt)
+	}
 }
 
 // getDefaultValues извлекает значения по умолчанию из структуры
@@ -67,8 +78,8 @@ func (rtc *RealTimeConfig) getCurrentValues(ctx context.Context) (map[ConfigName
 func (rtc *RealTimeConfig) applySync(ctx context.Context, defaults map[ConfigName]any, current map[ConfigName][]byte) error {
 	txn := rtc.client.Txn(ctx)
 	cfgValue := reflect.ValueOf(rtc.cfg).Elem()
-	fmt.Println(current)
 	var putOps []clientv3.Op
+	var cmps []clientv3.Cmp
 
 	for name, currentValBytes := range current {
 		field, ok := rtc.schema[name]
@@ -76,22 +87,14 @@ func (rtc *RealTimeConfig) applySync(ctx context.Context, defaults map[ConfigNam
 			continue
 		}
 
-		var etcdVal any
-		if err := json.Unmarshal(currentValBytes, &etcdVal); err != nil {
-			return fmt.Errorf("unmarshal error for %s: %w", name, err)
+		convertedVal, err := rtc.decodeField(ctx, field, currentValBytes)
+		if err != nil {
+			return fmt.Errorf("decode failed for %s: %w", name, err)
 		}
-		fmt.Println(etcdVal)
 
 		fieldValue := cfgValue.Field(field.FieldIdx)
-		currentCfgVal := fieldValue.Interface()
-
-		convertedVal, err := convertType(etcdVal, fieldValue.Type())
-		if err != nil {
-			return fmt.Errorf("type conversion failed for %s: %w", name, err)
-		}
-		if !reflect.DeepEqual(currentCfgVal, convertedVal) {
+		if !reflect.DeepEqual(fieldValue.Interface(), convertedVal) {
 			fieldValue.Set(reflect.ValueOf(convertedVal))
-			log.Println(rtc.cfg)
 		}
 	}
 
@@ -106,18 +109,21 @@ func (rtc *RealTimeConfig) applySync(ctx context.Context, defaults map[ConfigNam
 
 				fieldValue.Set(reflect.ValueOf(convertedVal))
 
-				value, err := json.Marshal(defVal)
+				value, err := rtc.encodeField(ctx, field, defVal)
 				if err != nil {
 					return fmt.Errorf("marshal error: %w", err)
 				}
-				putOps = append(putOps, clientv3.OpPut(rtc.prefix+"/"+string(name), string(value)))
+				// значение по умолчанию записывается, только если ключ всё ещё отсутствует
+				key := rtc.prefix + "/" + string(name)
+				cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+				putOps = append(putOps, clientv3.OpPut(key, string(value)))
 			}
 		}
 	}
 
 	var delOps []clientv3.Op
 	for name := range current {
-		if _, exists := rtc.schema[name]; !exists {
+		if _, exists := rtc.schema[name]; !exists && !isReservedName(name) {
 			delOps = append(delOps, clientv3.OpDelete(rtc.prefix+"/"+string(name)))
 		}
 	}
@@ -127,18 +133,84 @@ func (rtc *RealTimeConfig) applySync(ctx context.Context, defaults map[ConfigNam
 		ops = append(ops, putOps...)
 		ops = append(ops, delOps...)
 
-		txnResp, err := txn.Then(ops...).Commit()
+		txnResp, err := txn.If(cmps...).Then(ops...).Commit()
 		if err != nil {
 			return fmt.Errorf("sync transaction failed: %w", err)
 		}
 		if !txnResp.Succeeded {
-			return fmt.Errorf("sync transaction conflict")
+			return errSyncConflict
 		}
 	}
 
 	return nil
 }
 
+// decodeValue декодирует JSON-значение из etcd в тип поля.
+// Если значение не подходит напрямую (например, длительность в виде строки "5s"), используется convertType.
+func decodeValue(data []byte, targetType reflect.Type) (any, error) {
+	ptr := reflect.New(targetType)
+	if err := json.Unmarshal(data, ptr.Interface()); err == nil {
+		return ptr.Elem().Interface(), nil
+	}
+
+	var rawVal any
+	if err := json.Unmarshal(data, &rawVal); err != nil {
+		return nil, err
+	}
+
+	return convertType(rawVal, targetType)
+}
+
+// encodeField кодирует значение поля для хранения в etcd, секретные поля шифруются
+func (rtc *RealTimeConfig) encodeField(ctx context.Context, meta fieldSchema, value any) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if !meta.Secret {
+		return data, nil
+	}
+	if rtc.opts.keyProvider == nil {
+		return nil, ErrNoKeyProvider
+	}
+
+	return sealValue(ctx, rtc.opts.keyProvider, rtc.secretAAD(meta.Name), data)
+}
+
+// decodeField декодирует значение поля из etcd, расшифровывая секретные поля.
+// Незашифрованное значение секрета отвергается с ErrNotEncrypted, а с WithPlaintextSecrets
+// читается как есть: подписчики получают EventInsecure, пока значение не зашифрует RotateKeys.
+func (rtc *RealTimeConfig) decodeField(ctx context.Context, meta fieldSchema, data []byte) (any, error) {
+	if meta.Secret {
+		if rtc.opts.keyProvider == nil {
+			return nil, ErrNoKeyProvider
+		}
+		if _, encrypted := parseEnvelope(data); !encrypted {
+			if !rtc.opts.plaintextSecrets {
+				return nil, ErrNotEncrypted
+			}
+			rtc.insecureSecret(meta.Name)
+			return decodeValue(data, meta.Type)
+		}
+
+		plaintext, err := openValue(ctx, rtc.opts.keyProvider, rtc.secretAAD(meta.Name), data)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt failed: %w", err)
+		}
+		data = plaintext
+	}
+
+	return decodeValue(data, meta.Type)
+}
+
+// redact скрывает значения секретных полей перед выводом в логи, историю и экспорт
+func redact(meta fieldSchema, value any) any {
+	if meta.Secret {
+		return RedactedValue
+	}
+	return value
+}
+
 func convertType(val any, targetType reflect.Type) (any, error) {
 	sourceVal := reflect.ValueOf(val)
 	if targetType == reflect.TypeOf(map[string]struct{}{}) {
@@ -167,6 +239,8 @@ func convertType(val any, targetType reflect.Type) (any, error) {
 
 	if targetType == reflect.TypeOf(time.Duration(0)) {
 		switch v := val.(type) {
+		case time.Duration:
+			return v, nil
 		case float64:
 			return time.Duration(v), nil
 		case string:
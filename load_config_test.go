@@ -4,26 +4,18 @@ import (
 	"context"
 	"sync"
 	"testing"
-	"time"
 
+	"github.com/olefire/realtime-config-go/konfigtest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 func TestRealTimeConfig_Sync(t *testing.T) {
-
 	ctx := context.Background()
-	client, err := clientv3.New(clientv3.Config{
-		Endpoints:   []string{"localhost:2379"},
-		DialTimeout: 2 * time.Second,
-	})
-	require.NoError(t, err)
-	defer client.Close()
+	srv := konfigtest.New(t)
+	client := srv.Client
 
 	prefix := "/test/config/sync"
-	_, err = client.Delete(ctx, prefix, clientv3.WithPrefix())
-	require.NoError(t, err)
 
 	t.Run("Sync with empty etcd", func(t *testing.T) {
 		type Config struct {
@@ -83,16 +75,10 @@ func TestRealTimeConfig_Sync(t *testing.T) {
 
 func TestRealTimeConfig_ConcurrentSync(t *testing.T) {
 	ctx := context.Background()
-	client, err := clientv3.New(clientv3.Config{
-		Endpoints:   []string{"localhost:2379"},
-		DialTimeout: 2 * time.Second,
-	})
-	require.NoError(t, err)
-	defer client.Close()
+	srv := konfigtest.New(t)
+	client := srv.Client
 
 	prefix := "/test/config/concurrent"
-	_, err = client.Delete(ctx, prefix, clientv3.WithPrefix())
-	require.NoError(t, err)
 
 	type Config struct {
 		Counter int `etcd:"counter"`
@@ -128,16 +114,10 @@ func TestRealTimeConfig_ConcurrentSync(t *testing.T) {
 
 func TestRealTimeConfig_ComplexTypes(t *testing.T) {
 	ctx := context.Background()
-	client, err := clientv3.New(clientv3.Config{
-		Endpoints:   []string{"localhost:2379"},
-		DialTimeout: 2 * time.Second,
-	})
-	require.NoError(t, err)
-	defer client.Close()
+	srv := konfigtest.New(t)
+	client := srv.Client
 
 	prefix := "/test/config/complex"
-	_, err = client.Delete(ctx, prefix, clientv3.WithPrefix())
-	require.NoError(t, err)
 
 	t.Run("Slice type", func(t *testing.T) {
 		type Config struct {
@@ -159,9 +139,9 @@ func TestRealTimeConfig_ComplexTypes(t *testing.T) {
 		err = rtc.Set(ctx, "servers", updSlice)
 		require.NoError(t, err)
 
-		require.Eventually(t, func() bool {
-			return len(cfg.Servers) == 2 && cfg.Servers[0] == "server3"
-		}, time.Second, 100*time.Millisecond)
+		// Set не возвращает ревизию, поэтому ждём текущую: это ревизия записи Set
+		srv.Sync(t, rtc)
+		assert.Equal(t, updSlice, cfg.Servers)
 	})
 
 	t.Run("Map type", func(t *testing.T) {
@@ -182,3 +162,44 @@ func TestRealTimeConfig_ComplexTypes(t *testing.T) {
 		assert.Equal(t, expected, resp)
 	})
 }
+
+func TestRealTimeConfig_SyncConflict(t *testing.T) {
+	ctx := context.Background()
+	srv := konfigtest.New(t)
+	f := srv.Faults(t)
+
+	prefix := "/test/config/sync_conflict"
+
+	type Config struct {
+		Timeout int `etcd:"timeout"`
+	}
+
+	t.Run("Retried", func(t *testing.T) {
+		f.FailTxn(2)
+		cfg := &Config{Timeout: 30}
+		_, err := NewRealTimeConfig(ctx, f.Client, prefix, cfg)
+		require.NoError(t, err)
+
+		resp, err := srv.Client.Get(ctx, prefix+"/timeout")
+		require.NoError(t, err)
+		require.Len(t, resp.Kvs, 1)
+		assert.Equal(t, "30", string(resp.Kvs[0].Value))
+	})
+
+	t.Run("Keeps concurrent value", func(t *testing.T) {
+		srv.Put(t, prefix+"/timeout", 45)
+
+		cfg := &Config{Timeout: 30}
+		_, err := NewRealTimeConfig(ctx, f.Client, prefix, cfg)
+		require.NoError(t, err)
+		assert.Equal(t, 45, cfg.Timeout)
+	})
+
+	t.Run("Gives up", func(t *testing.T) {
+		f.FailTxn(syncRetries)
+		defer f.FailTxn(0)
+
+		_, err := NewRealTimeConfig(ctx, f.Client, prefix+"/other", &Config{Timeout: 30})
+		assert.ErrorIs(t, err, errSyncConflict)
+	})
+}
@@ -0,0 +1,252 @@
+package konfig
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Option настраивает RealTimeConfig при создании
+type Option func(*options)
+
+type options struct {
+	skipSync  bool
+	skipWatch bool
+
+	schemaVersion string
+	schemaTTL     time.Duration
+
+	keyProvider      KeyProvider
+	plaintextSecrets bool
+
+	overrideScopes []string
+
+	instanceID string
+
+	scheduler        bool
+	scheduleInterval time.Duration
+
+	tenant string
+
+	authorizer Authorizer
+
+	approvalTTL time.Duration
+
+	debounce    time.Duration
+	flapChanges int
+	flapWindow  time.Duration
+
+	healthProbe  HealthProbe
+	healthWindow time.Duration
+
+	derived    map[ConfigName]derivedFunc
+	validators []ValidateFunc
+
+	// instanceTTL lease ключей экземпляра под .status и .instances
+	instanceTTL  time.Duration
+	statusReport bool
+	register     bool
+	buildVersion string
+}
+
+// WithoutSync отключает синхронизацию etcd со значениями по умолчанию при старте.
+// Нужен клиентам, которые только читают и пишут ключи и не должны
+// перезаписывать или удалять чужие значения под префиксом.
+func WithoutSync() Option {
+	return func(o *options) {
+		o.skipSync = true
+	}
+}
+
+// WithoutWatch отключает фоновое отслеживание изменений в etcd
+func WithoutWatch() Option {
+	return func(o *options) {
+		o.skipWatch = true
+	}
+}
+
+// WithSchemaPublishing публикует схему под prefix/.schema/<version>, чтобы редакторы
+// конфига могли проверять значения без типов Go сервиса. Ключ живёт, пока жив
+// хотя бы один экземпляр этой версии, и удаляется через ttl после остановки последнего.
+func WithSchemaPublishing(version string, ttl time.Duration) Option {
+	return func(o *options) {
+		o.schemaVersion = version
+		o.schemaTTL = ttl
+	}
+}
+
+// WithKeyProvider задаёт провайдер ключей для полей с тегом secret:"true"
+func WithKeyProvider(kp KeyProvider) Option {
+	return func(o *options) {
+		o.keyProvider = kp
+	}
+}
+
+// WithPlaintextSecrets разрешает читать значения секретных полей, записанные открытым текстом
+// до того, как поле пометили secret. Опция нужна только на время миграции: подписчики получают
+// EventInsecure, пока значения не зашифрует RotateKeys. Без неё такие значения отвергаются,
+// иначе любой с правом записи в префикс подменил бы секрет без ключа.
+func WithPlaintextSecrets() Option {
+	return func(o *options) {
+		o.plaintextSecrets = true
+	}
+}
+
+// WithOverrideScopes включает переопределения prefix/_overrides/<scope>/<key> для этого экземпляра.
+// Scope перечисляются в порядке убывания приоритета, базовое значение применяется последним:
+//
+//	WithOverrideScopes(HostScope(), Scope("region", "eu"), Scope("env", "prod"))
+func WithOverrideScopes(scopes ...string) Option {
+	return func(o *options) {
+		o.overrideScopes = scopes
+	}
+}
+
+// WithInstanceID задаёт идентификатор экземпляра, по которому он попадает
+// или не попадает в долю поэтапной раскатки. По умолчанию <hostname>-<pid>.
+func WithInstanceID(id string) Option {
+	return func(o *options) {
+		o.instanceID = id
+	}
+}
+
+// WithScheduler включает участие экземпляра в выборах лидера планировщика.
+// Лидер раз в interval применяет наступившие изменения, сохранённые через Schedule.
+func WithScheduler(interval time.Duration) Option {
+	return func(o *options) {
+		o.scheduler = true
+		o.scheduleInterval = interval
+	}
+}
+
+// WithAuthorizer проверяет каждое изменение через Authorizer. Principal берётся
+// из контекста (см. WithPrincipal), отказы записываются в prefix/_audit/denied/.
+func WithAuthorizer(a Authorizer) Option {
+	return func(o *options) {
+		o.authorizer = a
+	}
+}
+
+// WithApprovalTTL задаёт, сколько предложение изменить поле с approval:"required"
+// ждёт одобрения. По умолчанию 24 часа.
+func WithApprovalTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.approvalTTL = ttl
+	}
+}
+
+// WithDebounce откладывает применение изменения ключа из watch, пока ключ не простоит
+// без изменений window. Все изменения за это время применяются одним, последним,
+// о чём подписчики получают EventCoalesced. Изменения одной транзакции и транзакций
+// с общими ключами откладываются и применяются вместе. AppliedRevision и WaitApplied при этом
+// отражают получение изменения, а не его отложенное применение, тогда как Status и регистрация
+// экземпляра публикуют ревизию до первого отложенного изменения.
+func WithDebounce(window time.Duration) Option {
+	return func(o *options) {
+		o.debounce = window
+	}
+}
+
+// WithFlapProtection замораживает ключ на последнем стабильном значении, если за window
+// он изменился больше maxChanges раз. Пока ключ заморожен, изменения из watch не применяются;
+// когда он простоит без изменений window, применяется последнее значение. Вместе с ключом
+// замораживаются ключи его транзакций, чтобы не применить транзакцию частично.
+// О заморозке и разморозке подписчики получают EventFrozen и EventThawed.
+func WithFlapProtection(maxChanges int, window time.Duration) Option {
+	return func(o *options) {
+		o.flapChanges = maxChanges
+		o.flapWindow = window
+	}
+}
+
+// WithHealthProbe наблюдает за probe в течение window после того, как watch применил новое
+// базовое значение поля. Если probe вернёт ошибку, ключ откатывается через RollbackKeyByRevision
+// к предыдущей ревизии, причина записывается в аудит, подписчики получают EventRolledBack.
+// Откат выполняется от имени principal с именем WithInstanceID, откаты не откатываются.
+func WithHealthProbe(probe HealthProbe, window time.Duration) Option {
+	return func(o *options) {
+		o.healthProbe = probe
+		o.healthWindow = window
+	}
+}
+
+// WithStatusReporting публикует статус экземпляра под prefix/.status/<instanceID>:
+// ревизию, до которой применены изменения, и значения, которые watch не смог применить.
+// Ключ привязан к lease: он удаляется при отмене ctx экземпляра или через ttl после его падения.
+// Статусы всех экземпляров возвращает ListInstanceStatus.
+func WithStatusReporting(ttl time.Duration) Option {
+	return func(o *options) {
+		o.statusReport = true
+		o.instanceTTL = ttl
+	}
+}
+
+// WithRegistration регистрирует экземпляр под prefix/.instances/<instanceID>: имя хоста,
+// версия сборки, применённая ревизия и хеш эффективного конфига. Ключ привязан к тому же
+// lease, что и статус WithStatusReporting; если заданы обе опции, действует последний ttl.
+// Живые экземпляры возвращает ListInstances.
+func WithRegistration(version string, ttl time.Duration) Option {
+	return func(o *options) {
+		o.register = true
+		o.buildVersion = version
+		o.instanceTTL = ttl
+	}
+}
+
+// WithDerived делает поле name производным: его значение не хранится в etcd, а вычисляется
+// fn из текущих значений deps при загрузке и пересчитывается при каждом их изменении.
+// Простые арифметические зависимости можно задать тегом поля:
+//
+//	TimeoutTotal time.Duration `etcd:"timeout_total" derive:"retries * per_try_timeout"`
+func WithDerived(name ConfigName, deps []ConfigName, fn DeriveFunc) Option {
+	return func(o *options) {
+		if o.derived == nil {
+			o.derived = make(map[ConfigName]derivedFunc)
+		}
+		o.derived[name] = derivedFunc{deps: deps, fn: fn}
+	}
+}
+
+// WithValidator добавляет проверку инвариантов между полями, дополняющую Validate конфига.
+// Изменение, при котором проверка возвращает ошибку, не применяется целиком.
+func WithValidator(fn ValidateFunc) Option {
+	return func(o *options) {
+		o.validators = append(o.validators, fn)
+	}
+}
+
+// withTenant настраивает конфиг арендатора внутри TenantManager: значения
+// prefix/_tenants/<id>/<key> применяются поверх глобальных. Синхронизацию, watch,
+// публикацию схемы и статуса и планировщик выполняет сам менеджер.
+func withTenant(id string) Option {
+	return func(o *options) {
+		o.tenant = id
+		o.skipSync = true
+		o.skipWatch = true
+		o.schemaVersion = ""
+		o.scheduler = false
+		o.statusReport = false
+		o.register = false
+	}
+}
+
+func buildOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.instanceID == "" {
+		hostname, _ := os.Hostname()
+		o.instanceID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if o.approvalTTL <= 0 {
+		o.approvalTTL = 24 * time.Hour
+	}
+	if o.scheduleInterval <= 0 {
+		o.scheduleInterval = time.Second
+	}
+	if o.healthProbe != nil && o.healthWindow <= 0 {
+		o.healthWindow = 30 * time.Second
+	}
+	return o
+}
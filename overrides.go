@@ -0,0 +1,403 @@
+package konfig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	overridesDir = "_overrides"
+
+	// BaseScope scope базовых значений prefix/<key>
+	BaseScope = ""
+)
+
+// Resolved эффективное значение поля и scope, из которого оно получено
+type Resolved struct {
+	Value any
+	Scope string
+}
+
+// Scope собирает имя scope переопределений, например Scope("region", "eu") = "region=eu"
+func Scope(kind, value string) string {
+	return kind + "=" + value
+}
+
+// HostScope возвращает scope переопределений для текущего хоста: host=<hostname>
+func HostScope() string {
+	hostname, _ := os.Hostname()
+	return Scope("host", hostname)
+}
+
+// SetOverride записывает значение поля в scope переопределений prefix/_overrides/<scope>/<key>.
+// Экземпляры, у которых этот scope включён через WithOverrideScopes, применят его поверх базового значения.
+// Изменение записывается в аудит в той же транзакции.
+func (rtc *RealTimeConfig) SetOverride(ctx context.Context, scope string, name ConfigName, value any) error {
+	meta, ok := rtc.schema[name]
+	if !ok {
+		return fmt.Errorf("unknown config field: %s", name)
+	}
+	if err := validateScope(scope); err != nil {
+		return err
+	}
+
+	convertedVal, err := convertType(value, meta.Type)
+	if err != nil {
+		return fmt.Errorf("type conversion failed for field %s: %w", name, err)
+	}
+	if err = checkRules(name, meta, convertedVal); err != nil {
+		return err
+	}
+	if err = rtc.authorize(ctx, name, OpOverride, convertedVal); err != nil {
+		return err
+	}
+	if err = rtc.requireNoApproval(name, OpOverride); err != nil {
+		return err
+	}
+	if err = rtc.validateChanges([]change{{name: name, field: meta, scope: scope, value: convertedVal, present: true}}); err != nil {
+		return err
+	}
+
+	data, err := rtc.encodeField(ctx, meta, convertedVal)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+	audit, err := auditRecord(ctx, OpOverride)
+	if err != nil {
+		return err
+	}
+
+	_, err = rtc.client.Txn(ctx).
+		Then(
+			clientv3.OpPut(rtc.overrideKey(scope, name), string(data)),
+			clientv3.OpPut(rtc.overrideAuditKey(scope, name), audit),
+		).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("etcd put failed: %w", err)
+	}
+
+	if rtc.hasScope(scope) {
+		rtc.applyValue(name, meta, scope, convertedVal, true)
+	}
+
+	return nil
+}
+
+// DeleteOverride удаляет переопределение, после чего экземпляры возвращаются к значению следующего scope
+func (rtc *RealTimeConfig) DeleteOverride(ctx context.Context, scope string, name ConfigName) error {
+	meta, ok := rtc.schema[name]
+	if !ok {
+		return fmt.Errorf("unknown config field: %s", name)
+	}
+	if err := validateScope(scope); err != nil {
+		return err
+	}
+	if err := rtc.authorize(ctx, name, OpOverride, nil); err != nil {
+		return err
+	}
+
+	if _, err := rtc.client.Delete(ctx, rtc.overrideKey(scope, name)); err != nil {
+		return fmt.Errorf("etcd delete failed: %w", err)
+	}
+
+	if rtc.hasScope(scope) {
+		rtc.applyValue(name, meta, scope, nil, false)
+	}
+
+	return nil
+}
+
+// Resolve читает из etcd эффективное значение поля с учётом scope этого экземпляра
+func (rtc *RealTimeConfig) Resolve(ctx context.Context, name ConfigName) (Resolved, error) {
+	meta, ok := rtc.schema[name]
+	if !ok {
+		return Resolved{}, fmt.Errorf("unknown config field: %s", name)
+	}
+
+	scopes := rtc.scopes()
+	ops := make([]clientv3.Op, 0, len(scopes))
+	for _, scope := range scopes {
+		ops = append(ops, clientv3.OpGet(rtc.scopeKey(scope, name)))
+	}
+
+	txnResp, err := rtc.client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return Resolved{}, fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	for i, resp := range txnResp.Responses {
+		kvs := resp.GetResponseRange().Kvs
+		if len(kvs) == 0 {
+			continue
+		}
+
+		data := kvs[0].Value
+		if scopes[i] == RolloutScope {
+			if data, ok, err = rtc.rolloutValue(name, data); err != nil {
+				return Resolved{}, fmt.Errorf("unmarshal failed: %w", err)
+			} else if !ok {
+				continue
+			}
+		}
+
+		val, err := rtc.decodeField(ctx, meta, data)
+		if err != nil {
+			return Resolved{}, fmt.Errorf("unmarshal failed: %w", err)
+		}
+		return Resolved{Value: val, Scope: scopes[i]}, nil
+	}
+
+	return Resolved{}, fmt.Errorf("%w in etcd: %s", ErrKeyNotFound, rtc.prefix+"/"+string(name))
+}
+
+// scopes возвращает scope этого экземпляра в порядке убывания приоритета:
+// временные значения, раскатка, переопределения, значения арендатора, базовый scope
+func (rtc *RealTimeConfig) scopes() []string {
+	scopes := make([]string, 0, len(rtc.opts.overrideScopes)+4)
+	scopes = append(scopes, TemporaryScope, RolloutScope)
+	scopes = append(scopes, rtc.opts.overrideScopes...)
+	if rtc.opts.tenant != "" {
+		scopes = append(scopes, TenantScope(rtc.opts.tenant))
+	}
+	return append(scopes, BaseScope)
+}
+
+func (rtc *RealTimeConfig) hasScope(scope string) bool {
+	for _, s := range rtc.scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (rtc *RealTimeConfig) overrideKey(scope string, name ConfigName) string {
+	return rtc.prefix + "/" + overridesDir + "/" + scope + "/" + string(name)
+}
+
+// overrideAuditKey ключ аудита переопределений prefix/_audit/changes/_overrides/<scope>/<key>
+func (rtc *RealTimeConfig) overrideAuditKey(scope string, name ConfigName) string {
+	return rtc.auditKey(ConfigName(overridesDir + "/" + scope + "/" + string(name)))
+}
+
+func (rtc *RealTimeConfig) scopeKey(scope string, name ConfigName) string {
+	switch scope {
+	case BaseScope:
+		return rtc.prefix + "/" + string(name)
+	case RolloutScope:
+		return rtc.rolloutKey(name)
+	}
+	if rtc.opts.tenant != "" && scope == TenantScope(rtc.opts.tenant) {
+		return rtc.tenantKey(rtc.opts.tenant, name)
+	}
+	return rtc.overrideKey(scope, name)
+}
+
+// parseKey разбирает ключ etcd на поле схемы и scope. Ключи чужих scope и служебные ключи пропускаются.
+func (rtc *RealTimeConfig) parseKey(key string) (ConfigName, string, bool) {
+	rel := strings.TrimPrefix(key, rtc.prefix+"/")
+
+	scope := BaseScope
+	if rest, ok := strings.CutPrefix(rel, overridesDir+"/"); ok {
+		scope, rel, ok = strings.Cut(rest, "/")
+		if !ok || !rtc.hasScope(scope) {
+			return "", "", false
+		}
+	} else if rest, ok := strings.CutPrefix(rel, rolloutsDir+"/"); ok {
+		scope, rel = RolloutScope, rest
+	} else if rest, ok := strings.CutPrefix(rel, tenantsDir+"/"); ok {
+		var id string
+		id, rel, ok = strings.Cut(rest, "/")
+		if !ok || rtc.opts.tenant == "" || id != rtc.opts.tenant {
+			return "", "", false
+		}
+		scope = TenantScope(id)
+	}
+
+	name := ConfigName(rel)
+	if _, ok := rtc.schema[name]; !ok {
+		return "", "", false
+	}
+
+	return name, scope, true
+}
+
+// applyValue обновляет значение поля в одном scope и записывает в cfg эффективное значение.
+// present=false удаляет значение из scope. Возвращает эффективное значение, его scope и признак изменения cfg.
+func (rtc *RealTimeConfig) applyValue(name ConfigName, meta fieldSchema, scope string, value any, present bool) (any, string, bool) {
+	r := rtc.applyChanges([]change{{name: name, field: meta, scope: scope, value: value, present: present}})
+	return r[0].effective, r[0].from, r[0].changed
+}
+
+// appliedChange результат применения изменения: эффективное значение поля, его scope и признак изменения cfg
+type appliedChange struct {
+	effective any
+	from      string
+	changed   bool
+}
+
+// applyChanges применяет изменения одной транзакции под одной блокировкой и один раз пересчитывает
+// производные поля, поэтому под rtc.mu конфиг не бывает виден с частью изменений транзакции.
+// Об изменении cfg, в том числе зависящих от полей производных, подписчики получают EventUpdated.
+func (rtc *RealTimeConfig) applyChanges(changes []change) []appliedChange {
+	results, events, _ := rtc.applyLayers(changes, false)
+	for _, ev := range events {
+		rtc.publish(ev)
+	}
+
+	return results
+}
+
+// applyValidChanges как applyChanges, но сначала проверяет инварианты Validator и WithValidator
+// под той же блокировкой, поэтому изменения применяются к тому конфигу, с которым проверены.
+// При нарушении инвариантов не применяется ни одно изменение.
+func (rtc *RealTimeConfig) applyValidChanges(changes []change) ([]appliedChange, error) {
+	results, events, err := rtc.applyLayers(changes, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, ev := range events {
+		rtc.publish(ev)
+	}
+
+	return results, nil
+}
+
+// layerValue возвращает значение поля в одном scope
+func (rtc *RealTimeConfig) layerValue(name ConfigName, scope string) (any, bool) {
+	rtc.mu.RLock()
+	defer rtc.mu.RUnlock()
+
+	value, ok := rtc.layers[name][scope]
+	return value, ok
+}
+
+// applyLayers обновляет слои полей и cfg, возвращает результаты по изменениям и события
+// об изменившихся полях, включая производные. validate проверяет инварианты до изменения cfg.
+func (rtc *RealTimeConfig) applyLayers(changes []change, validate bool) ([]appliedChange, []Event, error) {
+	rtc.mu.Lock()
+	defer rtc.mu.Unlock()
+
+	if validate && rtc.hasValidators() {
+		if err := rtc.validate(rtc.candidate(changes).Addr().Interface()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cfg := reflect.ValueOf(rtc.cfg).Elem()
+	results := make([]appliedChange, len(changes))
+	changed := make(map[ConfigName]bool)
+	var events []Event
+	for i, c := range changes {
+		layers := rtc.layers[c.name]
+		if layers == nil {
+			layers = make(map[string]any)
+			rtc.layers[c.name] = layers
+		}
+		if c.present {
+			layers[c.scope] = c.value
+		} else {
+			delete(layers, c.scope)
+		}
+
+		for _, s := range rtc.scopes() {
+			effective, ok := layers[s]
+			if !ok {
+				continue
+			}
+
+			results[i] = appliedChange{effective: effective, from: s}
+			fieldValue := cfg.Field(c.field.FieldIdx)
+			if !reflect.DeepEqual(fieldValue.Interface(), effective) {
+				setFieldValue(fieldValue, effective)
+				results[i].changed = true
+				changed[c.name] = true
+				events = append(events, Event{Type: EventUpdated, Key: c.name, Scope: s, Value: redact(c.field, effective)})
+			}
+			break
+		}
+	}
+	if len(changed) > 0 {
+		events = append(events, rtc.derive(cfg, changed)...)
+	}
+
+	return results, events, nil
+}
+
+// loadOverrides загружает переопределения scope этого экземпляра и текущие раскатки при старте.
+// Базовые ключи перечитываются в той же транзакции, поэтому вся загрузка соответствует одной
+// ревизии и запись базового ключа после syncWithDefaults не теряется, а при WithoutSync
+// базовые значения берутся из etcd. Значения проверяются так же, как в watch, и отвергнутые
+// попадают в Status, а не прерывают создание конфига.
+func (rtc *RealTimeConfig) loadOverrides(ctx context.Context) error {
+	for name, meta := range rtc.schema {
+		rtc.layers[name] = map[string]any{BaseScope: rtc.fieldValue(meta)}
+	}
+
+	dirs := []string{rolloutsDir, overridesDir}
+	if rtc.opts.tenant != "" {
+		dirs = append(dirs, tenantsDir+"/"+rtc.opts.tenant)
+	}
+
+	ops := make([]clientv3.Op, 0, len(dirs)+len(rtc.schema))
+	for _, dir := range dirs {
+		ops = append(ops, clientv3.OpGet(rtc.prefix+"/"+dir+"/", clientv3.WithPrefix()))
+	}
+	// базовые значения арендатора скопированы из глобального конфига,
+	// его ревизию выставляет TenantManager
+	if rtc.opts.tenant == "" {
+		for name := range rtc.schema {
+			ops = append(ops, clientv3.OpGet(rtc.prefix+"/"+string(name)))
+		}
+	}
+
+	resp, err := rtc.client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	// значения проходят тот же путь, что и события watch: недекодируемые и нарушающие правила
+	// отвергаются с EventRejected, а остальные проверяются на инварианты
+	var changes []change
+	for _, r := range resp.Responses {
+		for _, kv := range r.GetResponseRange().Kvs {
+			ev := &clientv3.Event{Type: clientv3.EventTypePut, Kv: kv}
+			if c, ok := rtc.decodeEvent(ctx, ev); ok {
+				changes = append(changes, c)
+			}
+		}
+	}
+	for _, c := range changes {
+		rtc.accept(c.key)
+	}
+	if _, err = rtc.applyValidChanges(changes); err != nil {
+		// записи могли появиться в разных транзакциях, поэтому при нарушении инвариантов
+		// значения применяются по одному и отвергаются только нарушающие
+		log.Printf("Loaded config violates invariants, applying values one by one: %v", err)
+		for _, c := range changes {
+			if _, err = rtc.applyValidChanges([]change{c}); err != nil {
+				log.Printf("Rejected value for %s: %v", c.name, err)
+				rtc.reject(c.key, c, err)
+			}
+		}
+	}
+	if rtc.opts.tenant == "" {
+		rtc.markApplied(resp.Header.Revision)
+	}
+
+	return nil
+}
+
+func validateScope(scope string) error {
+	if scope == BaseScope || strings.Contains(scope, "/") || isReservedName(ConfigName(scope)) {
+		return fmt.Errorf("invalid override scope %q", scope)
+	}
+	return nil
+}
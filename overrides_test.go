@@ -0,0 +1,174 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealTimeConfig_Overrides(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+	client := srv.Client
+
+	prefix := "/test/config/overrides"
+
+	type Config struct {
+		Timeout int    `etcd:"timeout"`
+		Mode    string `etcd:"mode"`
+	}
+
+	euCfg := &Config{Timeout: 30, Mode: "prod"}
+	eu, err := NewRealTimeConfig(ctx, client, prefix, euCfg,
+		WithOverrideScopes(Scope("host", "eu-1"), Scope("region", "eu")))
+	require.NoError(t, err)
+
+	usCfg := &Config{Timeout: 30, Mode: "prod"}
+	us, err := NewRealTimeConfig(ctx, client, prefix, usCfg, WithOverrideScopes(Scope("region", "us")))
+	require.NoError(t, err)
+
+	t.Run("Region override", func(t *testing.T) {
+		require.NoError(t, us.SetOverride(ctx, Scope("region", "eu"), "timeout", 45))
+
+		srv.Sync(t, eu)
+		val, err := eu.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 45, val)
+
+		resolved, err := eu.Resolve(ctx, "timeout")
+		require.NoError(t, err)
+		assert.Equal(t, Resolved{Value: 45, Scope: "region=eu"}, resolved)
+
+		resolved, err = us.Resolve(ctx, "timeout")
+		require.NoError(t, err)
+		assert.Equal(t, Resolved{Value: 30, Scope: BaseScope}, resolved)
+		assert.Equal(t, 30, usCfg.Timeout)
+	})
+
+	t.Run("Host wins over region", func(t *testing.T) {
+		require.NoError(t, eu.SetOverride(ctx, Scope("host", "eu-1"), "timeout", 60))
+		assert.Equal(t, 60, euCfg.Timeout)
+
+		val, err := eu.Get(ctx, "timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 60, val)
+	})
+
+	t.Run("Base change keeps override", func(t *testing.T) {
+		require.NoError(t, us.Set(ctx, "timeout", 90))
+		assert.Equal(t, 90, usCfg.Timeout)
+
+		srv.Sync(t, eu)
+		val, err := eu.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 60, val)
+	})
+
+	t.Run("Fallback on delete", func(t *testing.T) {
+		require.NoError(t, us.DeleteOverride(ctx, Scope("host", "eu-1"), "timeout"))
+
+		srv.Sync(t, eu)
+		val, err := eu.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 45, val)
+
+		require.NoError(t, us.DeleteOverride(ctx, Scope("region", "eu"), "timeout"))
+
+		srv.Sync(t, eu)
+		val, err = eu.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 90, val)
+	})
+
+	t.Run("Loaded on start", func(t *testing.T) {
+		require.NoError(t, us.SetOverride(ctx, Scope("region", "eu"), "mode", "canary"))
+
+		cfg := &Config{Timeout: 30, Mode: "prod"}
+		_, err := NewRealTimeConfig(ctx, client, prefix, cfg, WithOverrideScopes(Scope("region", "eu")))
+		require.NoError(t, err)
+		assert.Equal(t, "canary", cfg.Mode)
+	})
+
+	t.Run("Loaded at one revision", func(t *testing.T) {
+		// без синхронизации базовые значения читаются вместе с переопределениями
+		cfg := &Config{Timeout: 30, Mode: "prod"}
+		rtc, err := NewRealTimeConfig(ctx, client, prefix, cfg,
+			WithOverrideScopes(Scope("region", "eu")), WithoutSync(), WithoutWatch())
+		require.NoError(t, err)
+		assert.Equal(t, 90, cfg.Timeout)
+		assert.Equal(t, "canary", cfg.Mode)
+		assert.Equal(t, srv.Revision(t), rtc.AppliedRevision())
+	})
+
+	t.Run("Audited", func(t *testing.T) {
+		alice := WithChangeMeta(WithPrincipal(ctx, Principal{Name: "alice"}), ChangeMeta{Reason: "incident"})
+		require.NoError(t, eu.SetOverride(alice, Scope("region", "eu"), "mode", "safe"))
+
+		resp, err := client.Get(ctx, eu.overrideAuditKey(Scope("region", "eu"), "mode"))
+		require.NoError(t, err)
+		require.Len(t, resp.Kvs, 1)
+		var meta ChangeMeta
+		require.NoError(t, json.Unmarshal(resp.Kvs[0].Value, &meta))
+		assert.Equal(t, "alice", meta.Author)
+		assert.Equal(t, "incident", meta.Reason)
+		assert.Equal(t, OpOverride, meta.Operation)
+	})
+
+	t.Run("Invalid scope", func(t *testing.T) {
+		assert.Error(t, eu.SetOverride(ctx, "", "mode", "dev"))
+		assert.Error(t, eu.SetOverride(ctx, "a/b", "mode", "dev"))
+	})
+}
+
+func TestRealTimeConfig_OverridesRejectedOnStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/overrides/start"
+
+	type Config struct {
+		Timeout int `etcd:"timeout"`
+		Retries int `etcd:"retries" validate:"min=1"`
+		MinPool int `etcd:"min_pool"`
+		MaxPool int `etcd:"max_pool"`
+	}
+	poolOrder := WithValidator(func(cfg any) error {
+		if c := cfg.(*Config); c.MinPool > c.MaxPool {
+			return errors.New("min_pool must not exceed max_pool")
+		}
+		return nil
+	})
+
+	eu := Scope("region", "eu")
+	dir := prefix + "/" + overridesDir + "/" + eu + "/"
+	srv.Put(t, prefix+"/min_pool", 2)
+	srv.Put(t, dir+"timeout", 45)
+	srv.Put(t, dir+"retries", 0)
+	srv.PutRaw(t, dir+"min_pool", `"two"`)
+	srv.Put(t, dir+"max_pool", 1)
+
+	// при старте значения проверяются так же, как в watch, и не мешают создать конфиг
+	cfg := &Config{Timeout: 30, Retries: 3, MinPool: 2, MaxPool: 5}
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, cfg, WithOverrideScopes(eu), poolOrder)
+	require.NoError(t, err)
+
+	assert.Equal(t, 45, cfg.Timeout)
+	assert.Equal(t, 3, cfg.Retries)
+	assert.Equal(t, 2, cfg.MinPool)
+	assert.Equal(t, 5, cfg.MaxPool)
+
+	rejections := rtc.Status().Rejections
+	for _, name := range []string{"retries", "min_pool", "max_pool"} {
+		assert.Contains(t, rejections, overridesDir+"/"+eu+"/"+name)
+	}
+	assert.NotContains(t, rejections, overridesDir+"/"+eu+"/timeout")
+}
@@ -0,0 +1,147 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+var ErrNotPropagated = errors.New("change has not reached quorum of instances")
+
+// PropagationError возвращается из SetAndWait и WaitPropagated, если ctx завершился раньше,
+// чем нужная доля экземпляров применила изменение. Laggards экземпляры, которые его не применили.
+type PropagationError struct {
+	Revision  int64
+	Converged int
+	Required  int
+	Total     int
+	Laggards  []Instance
+	Err       error
+}
+
+func (e *PropagationError) Error() string {
+	laggards := make([]string, 0, len(e.Laggards))
+	for _, i := range e.Laggards {
+		laggards = append(laggards, fmt.Sprintf("%s (applied %d)", i.InstanceID, i.AppliedRevision))
+	}
+
+	return fmt.Sprintf("%v: revision %d applied by %d of %d instances, %d required, laggards: %s: %v",
+		ErrNotPropagated, e.Revision, e.Converged, e.Total, e.Required, strings.Join(laggards, ", "), e.Err)
+}
+
+func (e *PropagationError) Unwrap() []error {
+	return []error{ErrNotPropagated, e.Err}
+}
+
+// SetAndWait записывает значение поля и ждёт, пока доля quorum из (0, 1] экземпляров,
+// зарегистрированных через WithRegistration, применит его. Срок ожидания задаёт ctx.
+func (rtc *RealTimeConfig) SetAndWait(ctx context.Context, name ConfigName, value any, quorum float64) error {
+	if err := validateQuorum(quorum); err != nil {
+		return err
+	}
+
+	revision, err := rtc.setMany(ctx, map[ConfigName]any{name: value}, OpSet)
+	if err != nil {
+		return err
+	}
+
+	return rtc.WaitPropagated(ctx, revision, quorum)
+}
+
+// WaitPropagated ждёт, пока доля quorum из (0, 1] зарегистрированных экземпляров применит
+// изменения до ревизии revision включительно. Экземпляры, зарегистрированные или остановленные
+// во время ожидания, учитываются. Если не зарегистрирован ни один экземпляр, ждать некого.
+func (rtc *RealTimeConfig) WaitPropagated(ctx context.Context, revision int64, quorum float64) error {
+	if err := validateQuorum(quorum); err != nil {
+		return err
+	}
+
+	dir := rtc.prefix + "/" + instancesDir + "/"
+	resp, err := rtc.client.Get(ctx, dir, clientv3.WithPrefix())
+	if err != nil {
+		return fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	instances := make(map[string]Instance, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		if err = decodeInstance(instances, kv.Key, kv.Value); err != nil {
+			return err
+		}
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wch := rtc.client.Watch(wctx, dir, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+
+	for {
+		laggards := make([]Instance, 0)
+		for _, i := range instances {
+			if !i.Converged(revision) {
+				laggards = append(laggards, i)
+			}
+		}
+		// допуск на погрешность умножения, чтобы 0.7 от 10 было 7, а не 8
+		required := int(math.Ceil(quorum*float64(len(instances)) - 1e-9))
+		converged := len(instances) - len(laggards)
+		if converged >= required {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			sort.Slice(laggards, func(i, j int) bool {
+				return laggards[i].InstanceID < laggards[j].InstanceID
+			})
+			return &PropagationError{
+				Revision:  revision,
+				Converged: converged,
+				Required:  required,
+				Total:     len(instances),
+				Laggards:  laggards,
+				Err:       ctx.Err(),
+			}
+		case wr, ok := <-wch:
+			if !ok {
+				// watch закрывается вместе с ctx
+				wch = nil
+				continue
+			}
+			if err = wr.Err(); err != nil {
+				return fmt.Errorf("etcd watch failed: %w", err)
+			}
+			for _, ev := range wr.Events {
+				if ev.Type == clientv3.EventTypeDelete {
+					delete(instances, string(ev.Kv.Key))
+					continue
+				}
+				if err = decodeInstance(instances, ev.Kv.Key, ev.Kv.Value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func decodeInstance(instances map[string]Instance, key, value []byte) error {
+	var instance Instance
+	if err := json.Unmarshal(value, &instance); err != nil {
+		return fmt.Errorf("unmarshal failed for instance %s: %w", key, err)
+	}
+	instances[string(key)] = instance
+
+	return nil
+}
+
+func validateQuorum(quorum float64) error {
+	if quorum <= 0 || quorum > 1 {
+		return fmt.Errorf("quorum must be in (0, 1], got %v", quorum)
+	}
+
+	return nil
+}
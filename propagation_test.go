@@ -0,0 +1,114 @@
+package konfig
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealTimeConfig_SetAndWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/propagation"
+
+	type Config struct {
+		Mode string `etcd:"mode"`
+	}
+
+	cfg1 := &Config{}
+	rtc1, err := NewRealTimeConfig(ctx, srv.Client, prefix, cfg1,
+		WithInstanceID("node-1"), WithRegistration("v1", 5*time.Second))
+	require.NoError(t, err)
+
+	cfg2 := &Config{}
+	_, err = NewRealTimeConfig(ctx, srv.Client, prefix, cfg2,
+		WithInstanceID("node-2"), WithRegistration("v1", 5*time.Second))
+	require.NoError(t, err)
+
+	// экземпляр без watch не применяет изменения и отстаёт
+	_, err = NewRealTimeConfig(ctx, srv.Client, prefix, &Config{},
+		WithInstanceID("node-3"), WithRegistration("v1", 5*time.Second), WithoutWatch())
+	require.NoError(t, err)
+
+	srv.Await(t, prefix+"/"+instancesDir+"/", func(kvs map[string][]byte) bool {
+		return len(kvs) == 3
+	})
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+	require.NoError(t, rtc1.SetAndWait(waitCtx, "mode", "canary", 0.6))
+	assert.Equal(t, "canary", cfg1.Mode)
+	assert.Equal(t, "canary", cfg2.Mode)
+
+	waitCtx, waitCancel = context.WithTimeout(ctx, 3*time.Second)
+	defer waitCancel()
+	err = rtc1.SetAndWait(waitCtx, "mode", "prod", 1)
+	require.ErrorIs(t, err, ErrNotPropagated)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	var perr *PropagationError
+	require.True(t, errors.As(err, &perr))
+	assert.Equal(t, 2, perr.Converged)
+	assert.Equal(t, 3, perr.Required)
+	require.Len(t, perr.Laggards, 1)
+	assert.Equal(t, "node-3", perr.Laggards[0].InstanceID)
+	assert.Contains(t, err.Error(), "node-3")
+	assert.Equal(t, "prod", cfg2.Mode)
+
+	assert.Error(t, rtc1.SetAndWait(ctx, "mode", "prod", 1.5))
+}
+
+func TestRealTimeConfig_WaitPropagatedPending(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/propagation/pending"
+
+	type Config struct {
+		Mode string `etcd:"mode"`
+	}
+
+	rtc1, err := NewRealTimeConfig(ctx, srv.Client, prefix, &Config{},
+		WithInstanceID("node-1"), WithRegistration("v1", 5*time.Second))
+	require.NoError(t, err)
+
+	// node-2 получает изменения сразу, а применяет через 3s
+	rtc2, err := NewRealTimeConfig(ctx, srv.Client, prefix, &Config{},
+		WithInstanceID("node-2"), WithRegistration("v1", 5*time.Second), WithDebounce(3*time.Second))
+	require.NoError(t, err)
+
+	srv.Await(t, prefix+"/"+instancesDir+"/", func(kvs map[string][]byte) bool {
+		return len(kvs) == 2
+	})
+
+	revision, err := rtc1.setMany(ctx, map[ConfigName]any{"mode": "canary"}, OpSet)
+	require.NoError(t, err)
+	konfigtest.WaitApplied(t, rtc2, revision)
+	assert.Less(t, rtc2.Status().AppliedRevision, revision)
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 1500*time.Millisecond)
+	defer waitCancel()
+	err = rtc1.WaitPropagated(waitCtx, revision, 1)
+	var perr *PropagationError
+	require.ErrorAs(t, err, &perr)
+	require.Len(t, perr.Laggards, 1)
+	assert.Equal(t, "node-2", perr.Laggards[0].InstanceID)
+
+	waitCtx, waitCancel = context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+	require.NoError(t, rtc1.WaitPropagated(waitCtx, revision, 1))
+	mode, err := rtc2.Value("mode")
+	require.NoError(t, err)
+	assert.Equal(t, "canary", mode)
+	assert.GreaterOrEqual(t, rtc2.Status().AppliedRevision, revision)
+}
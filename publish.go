@@ -0,0 +1,146 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const schemaDir = ".schema"
+
+var (
+	ErrSchemaNotFound = errors.New("published schema not found")
+)
+
+// PublishedSchema схема сервиса, опубликованная в etcd
+type PublishedSchema struct {
+	Version     string      `json:"version"`
+	Fields      []FieldInfo `json:"fields"`
+	PublishedAt time.Time   `json:"published_at"`
+}
+
+// isReservedName сообщает, является ли имя служебным: такие ключи под префиксом
+// принадлежат библиотеке и не синхронизируются со схемой
+func isReservedName(name ConfigName) bool {
+	return strings.HasPrefix(string(name), ".") || strings.HasPrefix(string(name), "_")
+}
+
+// ListSchemaVersions возвращает версии сервиса, опубликовавшие схему под префиксом
+func ListSchemaVersions(ctx context.Context, cli *clientv3.Client, prefix string) ([]string, error) {
+	dir := prefix + "/" + schemaDir + "/"
+	resp, err := cli.Get(ctx, dir, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	versions := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		versions = append(versions, strings.TrimPrefix(string(kv.Key), dir))
+	}
+	sort.Strings(versions)
+
+	return versions, nil
+}
+
+// LoadSchema читает опубликованную схему указанной версии сервиса
+func LoadSchema(ctx context.Context, cli *clientv3.Client, prefix, version string) (*PublishedSchema, error) {
+	resp, err := cli.Get(ctx, prefix+"/"+schemaDir+"/"+version)
+	if err != nil {
+		return nil, fmt.Errorf("etcd get failed: %w", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("%w: version %s", ErrSchemaNotFound, version)
+	}
+
+	var ps PublishedSchema
+	if err = json.Unmarshal(resp.Kvs[0].Value, &ps); err != nil {
+		return nil, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return &ps, nil
+}
+
+// publishSchema записывает схему под prefix/.schema/<version> с привязкой к lease.
+// Пока процесс жив, lease продлевается; когда остановятся все экземпляры версии, ключ удалится сам.
+func (rtc *RealTimeConfig) publishSchema(ctx context.Context) error {
+	data, err := json.Marshal(PublishedSchema{
+		Version:     rtc.opts.schemaVersion,
+		Fields:      rtc.Schema(),
+		PublishedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	key := rtc.prefix + "/" + schemaDir + "/" + rtc.opts.schemaVersion
+	lease, lost, err := rtc.grantLease(ctx, rtc.opts.schemaTTL)
+	if err != nil {
+		return err
+	}
+	resp, err := rtc.client.Put(ctx, key, string(data), clientv3.WithLease(lease))
+	if err != nil {
+		rtc.revokeLease(lease, "schema")
+		return fmt.Errorf("etcd put failed: %w", err)
+	}
+
+	go rtc.keepSchemaPublished(ctx, key, data, resp.Header.Revision, lease, lost)
+
+	return nil
+}
+
+// keepSchemaPublished публикует схему заново, если ключ удалил другой экземпляр той же версии
+// (его lease истёк) или пропал lease этого процесса. Ключ остаётся на одном lease, новый
+// выдаётся только взамен пропавшего. После отмены ctx lease отзывается, и схема удаляется сразу.
+func (rtc *RealTimeConfig) keepSchemaPublished(ctx context.Context, key string, data []byte, rev int64,
+	lease clientv3.LeaseID, lost <-chan struct{}) {
+	defer func() {
+		if lease != 0 {
+			rtc.revokeLease(lease, "schema")
+		}
+	}()
+
+	republish := func() {
+		var err error
+		if lease == 0 {
+			if lease, lost, err = rtc.grantLease(ctx, rtc.opts.schemaTTL); err != nil {
+				log.Printf("Failed to republish schema %s: %v", rtc.opts.schemaVersion, err)
+				return
+			}
+		}
+		if _, err = rtc.client.Put(ctx, key, string(data), clientv3.WithLease(lease)); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to republish schema %s: %v", rtc.opts.schemaVersion, err)
+		}
+	}
+
+	wch := rtc.client.Watch(ctx, key, clientv3.WithRev(rev+1))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-lost:
+			if ctx.Err() != nil {
+				return
+			}
+			// продление могло прерваться при живом lease, поэтому старый отзывается явно
+			rtc.revokeLease(lease, "schema")
+			lease, lost = 0, nil
+			republish()
+		case wr, ok := <-wch:
+			if !ok {
+				return
+			}
+			for _, ev := range wr.Events {
+				if ev.Type == clientv3.EventTypeDelete {
+					republish()
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package konfig
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestRealTimeConfig_PublishSchema(t *testing.T) {
+	ctx := context.Background()
+	srv := konfigtest.New(t)
+	client := srv.Client
+
+	prefix := "/test/config/publish"
+
+	type Config struct {
+		Timeout int `etcd:"timeout" desc:"request timeout"`
+	}
+
+	svcCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	_, err := NewRealTimeConfig(svcCtx, client, prefix, &Config{Timeout: 30}, WithSchemaPublishing("v1.2.0", 1500*time.Millisecond))
+	require.NoError(t, err)
+
+	t.Run("Discovery", func(t *testing.T) {
+		versions, err := ListSchemaVersions(ctx, client, prefix)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"v1.2.0"}, versions)
+
+		ps, err := LoadSchema(ctx, client, prefix, "v1.2.0")
+		require.NoError(t, err)
+		require.Len(t, ps.Fields, 1)
+		assert.Equal(t, ConfigName("timeout"), ps.Fields[0].Name)
+		assert.Equal(t, "request timeout", ps.Fields[0].Description)
+
+		_, err = LoadSchema(ctx, client, prefix, "v0.0.1")
+		assert.ErrorIs(t, err, ErrSchemaNotFound)
+	})
+
+	t.Run("Sync keeps reserved keys", func(t *testing.T) {
+		_, err := NewRealTimeConfig(ctx, client, prefix, &Config{Timeout: 30}, WithoutWatch())
+		require.NoError(t, err)
+
+		versions, err := ListSchemaVersions(ctx, client, prefix)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"v1.2.0"}, versions)
+	})
+
+	t.Run("Republished on the same lease", func(t *testing.T) {
+		key := prefix + "/" + schemaDir + "/v1.2.0"
+		resp, err := client.Get(ctx, key)
+		require.NoError(t, err)
+		require.Len(t, resp.Kvs, 1)
+		lease := resp.Kvs[0].Lease
+
+		ttl, err := client.TimeToLive(ctx, clientv3.LeaseID(lease))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), ttl.GrantedTTL)
+
+		srv.Delete(t, key)
+		srv.Await(t, key, func(kvs map[string][]byte) bool {
+			return kvs[key] != nil
+		})
+
+		resp, err = client.Get(ctx, key)
+		require.NoError(t, err)
+		require.Len(t, resp.Kvs, 1)
+		assert.Equal(t, lease, resp.Kvs[0].Lease)
+
+		leases, err := client.Leases(ctx)
+		require.NoError(t, err)
+		assert.Len(t, leases.Leases, 1)
+	})
+
+	t.Run("Expires after shutdown", func(t *testing.T) {
+		stop()
+
+		srv.Await(t, prefix+"/"+schemaDir+"/", func(kvs map[string][]byte) bool {
+			return len(kvs) == 0
+		})
+		versions, err := ListSchemaVersions(ctx, client, prefix)
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+}
@@ -0,0 +1,319 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	rolloutsDir = "_rollouts"
+
+	// RolloutScope scope, из которого применяется значение поэтапной раскатки
+	RolloutScope = "_rollout"
+)
+
+var (
+	ErrNoRollout       = errors.New("no rollout in progress")
+	ErrRolloutConflict = errors.New("rollout changed concurrently")
+	ErrRolloutAborted  = errors.New("rollout aborted")
+)
+
+// Rollout поэтапная раскатка значения: экземпляры, чей стабильный хеш
+// попадает в Percent, применяют Value поверх остальных scope
+type Rollout struct {
+	Value     json.RawMessage `json:"value"`
+	Percent   float64         `json:"percent"`
+	StartedAt time.Time       `json:"started_at"`
+}
+
+// RolloutPlan план автоматической раскатки
+type RolloutPlan struct {
+	// Steps проценты экземпляров на каждом шаге, например 1, 10, 50
+	Steps []float64
+	// StepInterval сколько ждать на каждом шаге и после продвижения перед проверкой Health
+	StepInterval time.Duration
+	// Health проверка состояния сервиса, ошибка останавливает раскатку
+	Health func(ctx context.Context) error
+}
+
+// StartRollout публикует ожидающее значение поля для percent процентов экземпляров
+func (rtc *RealTimeConfig) StartRollout(ctx context.Context, name ConfigName, value any, percent float64) error {
+	meta, ok := rtc.schema[name]
+	if !ok {
+		return fmt.Errorf("unknown config field: %s", name)
+	}
+
+	convertedVal, err := convertType(value, meta.Type)
+	if err != nil {
+		return fmt.Errorf("type conversion failed for field %s: %w", name, err)
+	}
+	if err = checkRules(name, meta, convertedVal); err != nil {
+		return err
+	}
+	if err = rtc.authorize(ctx, name, OpRollout, convertedVal); err != nil {
+		return err
+	}
+	if err = rtc.requireNoApproval(name, OpRollout); err != nil {
+		return err
+	}
+	if err = rtc.validateChanges([]change{{name: name, field: meta, scope: RolloutScope, value: convertedVal, present: true}}); err != nil {
+		return err
+	}
+
+	data, err := rtc.encodeField(ctx, meta, convertedVal)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	return rtc.putRollout(ctx, name, Rollout{
+		Value:     data,
+		Percent:   percent,
+		StartedAt: time.Now().UTC(),
+	}, 0)
+}
+
+// GetRollout возвращает текущую раскатку поля
+func (rtc *RealTimeConfig) GetRollout(ctx context.Context, name ConfigName) (*Rollout, error) {
+	r, _, err := rtc.getRollout(ctx, name)
+	return r, err
+}
+
+// SetRolloutPercent меняет долю экземпляров, применяющих ожидающее значение
+func (rtc *RealTimeConfig) SetRolloutPercent(ctx context.Context, name ConfigName, percent float64) error {
+	r, modRev, err := rtc.getRollout(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err = rtc.authorize(ctx, name, OpRollout, nil); err != nil {
+		return err
+	}
+
+	r.Percent = percent
+	return rtc.putRollout(ctx, name, *r, modRev)
+}
+
+// PromoteRollout записывает ожидающее значение как базовое и завершает раскатку одной транзакцией.
+// Значение проходит ту же проверку и попадает в аудит, что и при Set.
+// Возвращает ревизию базового ключа до продвижения, к которой можно откатиться.
+func (rtc *RealTimeConfig) PromoteRollout(ctx context.Context, name ConfigName) (int64, error) {
+	r, modRev, err := rtc.getRollout(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	if err = rtc.authorize(ctx, name, OpRollout, nil); err != nil {
+		return 0, err
+	}
+	if err = rtc.requireNoApproval(name, OpRollout); err != nil {
+		return 0, err
+	}
+
+	meta := rtc.schema[name]
+	val, err := rtc.decodeField(ctx, meta, r.Value)
+	if err != nil {
+		return 0, fmt.Errorf("unmarshal failed for rollout %s: %w", name, err)
+	}
+
+	baseKey := rtc.prefix + "/" + string(name)
+	baseResp, err := rtc.client.Get(ctx, baseKey)
+	if err != nil {
+		return 0, fmt.Errorf("etcd get failed: %w", err)
+	}
+	var prevRev int64
+	if len(baseResp.Kvs) > 0 {
+		prevRev = baseResp.Kvs[0].ModRevision
+	}
+
+	rolloutKey := rtc.rolloutKey(name)
+	_, err = rtc.setManyIf(ctx, map[ConfigName]any{name: val}, OpRollout,
+		[]clientv3.Cmp{
+			clientv3.Compare(clientv3.ModRevision(rolloutKey), "=", modRev),
+			clientv3.Compare(clientv3.ModRevision(baseKey), "=", prevRev),
+		},
+		clientv3.OpDelete(rolloutKey))
+	if errors.Is(err, errTxnConflict) {
+		return 0, ErrRolloutConflict
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rtc.applyValue(name, meta, RolloutScope, nil, false)
+
+	return prevRev, nil
+}
+
+// AbortRollout отменяет раскатку, экземпляры возвращаются к прежнему значению
+func (rtc *RealTimeConfig) AbortRollout(ctx context.Context, name ConfigName) error {
+	meta, ok := rtc.schema[name]
+	if !ok {
+		return fmt.Errorf("unknown config field: %s", name)
+	}
+	if err := rtc.authorize(ctx, name, OpRollout, nil); err != nil {
+		return err
+	}
+
+	resp, err := rtc.client.Delete(ctx, rtc.rolloutKey(name))
+	if err != nil {
+		return fmt.Errorf("etcd delete failed: %w", err)
+	}
+	if resp.Deleted == 0 {
+		return fmt.Errorf("%w for %s", ErrNoRollout, name)
+	}
+
+	rtc.applyValue(name, meta, RolloutScope, nil, false)
+
+	return nil
+}
+
+// RunRollout раскатывает значение по шагам плана, проверяя Health после каждого шага.
+// При ошибке Health раскатка отменяется, а если значение уже продвинуто -
+// поле откатывается к предыдущей ревизии.
+func (rtc *RealTimeConfig) RunRollout(ctx context.Context, name ConfigName, value any, plan RolloutPlan) error {
+	if len(plan.Steps) == 0 {
+		return fmt.Errorf("rollout plan has no steps")
+	}
+
+	for i, percent := range plan.Steps {
+		var err error
+		if i == 0 {
+			err = rtc.StartRollout(ctx, name, value, percent)
+		} else {
+			err = rtc.SetRolloutPercent(ctx, name, percent)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = rtc.checkRolloutHealth(ctx, plan); err != nil {
+			if abortErr := rtc.AbortRollout(context.WithoutCancel(ctx), name); abortErr != nil {
+				log.Printf("Failed to abort rollout of %s: %v", name, abortErr)
+			}
+			return fmt.Errorf("%w at %v%%: %w", ErrRolloutAborted, percent, err)
+		}
+	}
+
+	prevRev, err := rtc.PromoteRollout(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if err = rtc.checkRolloutHealth(ctx, plan); err != nil {
+		if prevRev > 0 {
+			if rbErr := rtc.RollbackKeyByRevision(context.WithoutCancel(ctx), name, prevRev); rbErr != nil {
+				return fmt.Errorf("%w after promotion: %w (rollback failed: %v)", ErrRolloutAborted, err, rbErr)
+			}
+		}
+		return fmt.Errorf("%w after promotion: %w", ErrRolloutAborted, err)
+	}
+
+	return nil
+}
+
+func (rtc *RealTimeConfig) checkRolloutHealth(ctx context.Context, plan RolloutPlan) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(plan.StepInterval):
+	}
+
+	if plan.Health == nil {
+		return nil
+	}
+	return plan.Health(ctx)
+}
+
+func (rtc *RealTimeConfig) getRollout(ctx context.Context, name ConfigName) (*Rollout, int64, error) {
+	if _, ok := rtc.schema[name]; !ok {
+		return nil, 0, fmt.Errorf("unknown config field: %s", name)
+	}
+
+	resp, err := rtc.client.Get(ctx, rtc.rolloutKey(name))
+	if err != nil {
+		return nil, 0, fmt.Errorf("etcd get failed: %w", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, 0, fmt.Errorf("%w for %s", ErrNoRollout, name)
+	}
+
+	var r Rollout
+	if err = json.Unmarshal(resp.Kvs[0].Value, &r); err != nil {
+		return nil, 0, fmt.Errorf("unmarshal failed: %w", err)
+	}
+
+	return &r, resp.Kvs[0].ModRevision, nil
+}
+
+// putRollout записывает раскатку, если ключ не менялся с ревизии modRev (0 - ключ отсутствует или перезаписывается).
+// Значение раскатки проверяется по инвариантам конфига: с момента запуска он мог измениться.
+func (rtc *RealTimeConfig) putRollout(ctx context.Context, name ConfigName, r Rollout, modRev int64) error {
+	if r.Percent < 0 || r.Percent > 100 {
+		return fmt.Errorf("rollout percent must be within [0, 100], got %v", r.Percent)
+	}
+
+	meta := rtc.schema[name]
+	val, err := rtc.decodeField(ctx, meta, r.Value)
+	if err != nil {
+		return fmt.Errorf("unmarshal failed for rollout %s: %w", name, err)
+	}
+	if err = rtc.validateChanges([]change{{name: name, field: meta, scope: RolloutScope, value: val, present: true}}); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	key := rtc.rolloutKey(name)
+	txn := rtc.client.Txn(ctx)
+	if modRev > 0 {
+		txn = txn.If(clientv3.Compare(clientv3.ModRevision(key), "=", modRev))
+	}
+
+	txnResp, err := txn.Then(clientv3.OpPut(key, string(data))).Commit()
+	if err != nil {
+		return fmt.Errorf("etcd put failed: %w", err)
+	}
+	if !txnResp.Succeeded {
+		return ErrRolloutConflict
+	}
+
+	if cohortBucket(name, rtc.opts.instanceID) < r.Percent {
+		rtc.applyValue(name, meta, RolloutScope, val, true)
+	} else {
+		rtc.applyValue(name, meta, RolloutScope, nil, false)
+	}
+
+	return nil
+}
+
+// rolloutValue возвращает значение раскатки, если этот экземпляр входит в её долю
+func (rtc *RealTimeConfig) rolloutValue(name ConfigName, data []byte) ([]byte, bool, error) {
+	var r Rollout
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, false, err
+	}
+
+	return r.Value, cohortBucket(name, rtc.opts.instanceID) < r.Percent, nil
+}
+
+func (rtc *RealTimeConfig) rolloutKey(name ConfigName) string {
+	return rtc.prefix + "/" + rolloutsDir + "/" + string(name)
+}
+
+// cohortBucket отображает экземпляр в число из [0, 100) стабильно для каждого поля
+func cohortBucket(name ConfigName, instanceID string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	h.Write([]byte{':'})
+	h.Write([]byte(instanceID))
+
+	return float64(h.Sum64()%10000) / 100
+}
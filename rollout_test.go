@@ -0,0 +1,163 @@
+package konfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealTimeConfig_Rollout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+	client := srv.Client
+
+	prefix := "/test/config/rollout"
+
+	type Config struct {
+		Timeout int    `etcd:"timeout"`
+		Mode    string `etcd:"mode" validate:"oneof=prod canary broken"`
+	}
+
+	// подбираем экземпляры по обе стороны от 50%
+	instanceID := func(inCohort bool) string {
+		for i := 0; ; i++ {
+			id := fmt.Sprintf("instance-%d", i)
+			if (cohortBucket("timeout", id) < 50) == inCohort {
+				return id
+			}
+		}
+	}
+
+	canaryCfg := &Config{Timeout: 30, Mode: "prod"}
+	canary, err := NewRealTimeConfig(ctx, client, prefix, canaryCfg, WithInstanceID(instanceID(true)))
+	require.NoError(t, err)
+
+	stableCfg := &Config{Timeout: 30, Mode: "prod"}
+	stable, err := NewRealTimeConfig(ctx, client, prefix, stableCfg, WithInstanceID(instanceID(false)))
+	require.NoError(t, err)
+
+	t.Run("Canary cohort", func(t *testing.T) {
+		require.NoError(t, stable.StartRollout(ctx, "timeout", 45, 50))
+
+		srv.Sync(t, canary)
+		val, err := canary.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 45, val)
+
+		resolved, err := canary.Resolve(ctx, "timeout")
+		require.NoError(t, err)
+		assert.Equal(t, Resolved{Value: 45, Scope: RolloutScope}, resolved)
+
+		val, err = stable.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 30, val)
+
+		r, err := stable.GetRollout(ctx, "timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 50.0, r.Percent)
+	})
+
+	t.Run("Widen and promote", func(t *testing.T) {
+		require.NoError(t, canary.SetRolloutPercent(ctx, "timeout", 100))
+
+		srv.Sync(t, stable)
+		val, err := stable.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 45, val)
+
+		_, err = canary.PromoteRollout(WithChangeMeta(ctx, ChangeMeta{Author: "alice"}), "timeout")
+		require.NoError(t, err)
+
+		history, err := stable.GetKeyHistory(ctx, "timeout", 0, 1)
+		require.NoError(t, err)
+		require.NotNil(t, history[0].Meta)
+		assert.Equal(t, "alice", history[0].Meta.Author)
+		assert.Equal(t, OpRollout, history[0].Meta.Operation)
+
+		resolved, err := stable.Resolve(ctx, "timeout")
+		require.NoError(t, err)
+		assert.Equal(t, Resolved{Value: 45, Scope: BaseScope}, resolved)
+
+		_, err = stable.GetRollout(ctx, "timeout")
+		assert.ErrorIs(t, err, ErrNoRollout)
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		require.NoError(t, stable.StartRollout(ctx, "timeout", 60, 100))
+
+		srv.Sync(t, canary)
+		val, err := canary.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 60, val)
+
+		require.NoError(t, stable.AbortRollout(ctx, "timeout"))
+
+		srv.Sync(t, canary)
+		val, err = canary.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 45, val)
+		assert.ErrorIs(t, stable.AbortRollout(ctx, "timeout"), ErrNoRollout)
+	})
+
+	t.Run("Invalid rollout", func(t *testing.T) {
+		assert.ErrorIs(t, stable.StartRollout(ctx, "mode", "dev", 10), ErrValidation)
+		assert.Error(t, stable.StartRollout(ctx, "mode", "canary", 150))
+	})
+
+	t.Run("Run with failing canary", func(t *testing.T) {
+		errUnhealthy := errors.New("error rate too high")
+
+		err := stable.RunRollout(ctx, "mode", "broken", RolloutPlan{
+			Steps:        []float64{50, 100},
+			StepInterval: 50 * time.Millisecond,
+			Health: func(ctx context.Context) error {
+				if canaryCfg.Mode == "broken" || stableCfg.Mode == "broken" {
+					return errUnhealthy
+				}
+				return nil
+			},
+		})
+		require.ErrorIs(t, err, ErrRolloutAborted)
+		assert.ErrorIs(t, err, errUnhealthy)
+
+		_, err = stable.GetRollout(ctx, "mode")
+		assert.ErrorIs(t, err, ErrNoRollout)
+
+		val, err := stable.Get(ctx, "mode")
+		require.NoError(t, err)
+		assert.Equal(t, "prod", val)
+	})
+
+	t.Run("Rollback after promotion", func(t *testing.T) {
+		promoted := false
+		err := stable.RunRollout(ctx, "mode", "canary", RolloutPlan{
+			Steps:        []float64{100},
+			StepInterval: 50 * time.Millisecond,
+			Health: func(ctx context.Context) error {
+				if promoted {
+					return errors.New("fleet degraded")
+				}
+				promoted = true
+				return nil
+			},
+		})
+		require.ErrorIs(t, err, ErrRolloutAborted)
+
+		val, err := stable.Get(ctx, "mode")
+		require.NoError(t, err)
+		assert.Equal(t, "prod", val)
+
+		srv.Sync(t, canary)
+		val, err = canary.Value("mode")
+		require.NoError(t, err)
+		assert.Equal(t, "prod", val)
+	})
+}
@@ -0,0 +1,339 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const (
+	scheduleDir        = "_schedule"
+	schedulerElector   = ".scheduler"
+	failedSchedulesDir = "_audit/schedules"
+)
+
+var (
+	ErrScheduleNotFound = errors.New("scheduled change not found")
+	errSessionExpired   = errors.New("scheduler session expired")
+	errScheduleFailed   = errors.New("scheduled change cannot be applied")
+)
+
+// ScheduledChange отложенное изменение поля, которое применится в момент At
+type ScheduledChange struct {
+	ID    string     `json:"id"`
+	Key   ConfigName `json:"key"`
+	Value any        `json:"value"`
+	At    time.Time  `json:"at"`
+}
+
+// FailedSchedule запись аудита об изменении, которое планировщик не смог применить и удалил
+type FailedSchedule struct {
+	ID    string     `json:"id"`
+	Key   ConfigName `json:"key,omitempty"`
+	At    time.Time  `json:"at,omitempty"`
+	Meta  ChangeMeta `json:"meta"`
+	Error string     `json:"error"`
+	Time  time.Time  `json:"time"`
+}
+
+type scheduleRecord struct {
+	Key   ConfigName      `json:"key"`
+	Value json.RawMessage `json:"value"`
+	At    time.Time       `json:"at"`
+	// Meta сведения об авторе, которые попадут в аудит при применении
+	Meta ChangeMeta `json:"meta"`
+	// Principal автор изменения, с его правами изменение применяется
+	Principal Principal `json:"principal"`
+}
+
+// Schedule сохраняет изменение поля в prefix/_schedule/<id>. Изменение применит
+// ровно один раз экземпляр, выбранный лидером планировщика (см. WithScheduler).
+func (rtc *RealTimeConfig) Schedule(ctx context.Context, name ConfigName, value any, at time.Time) (string, error) {
+	meta, ok := rtc.schema[name]
+	if !ok {
+		return "", fmt.Errorf("unknown config field: %s", name)
+	}
+
+	convertedVal, err := convertType(value, meta.Type)
+	if err != nil {
+		return "", fmt.Errorf("type conversion failed for field %s: %w", name, err)
+	}
+	if err = checkRules(name, meta, convertedVal); err != nil {
+		return "", err
+	}
+	if err = rtc.authorize(ctx, name, OpSchedule, convertedVal); err != nil {
+		return "", err
+	}
+	if err = rtc.requireNoApproval(name, OpSchedule); err != nil {
+		return "", err
+	}
+
+	data, err := rtc.encodeField(ctx, meta, convertedVal)
+	if err != nil {
+		return "", fmt.Errorf("marshal error: %w", err)
+	}
+
+	principal, _ := PrincipalFromContext(ctx)
+	changeMeta, _ := ChangeMetaFromContext(ctx)
+	if changeMeta.Author == "" {
+		changeMeta.Author = principal.Name
+	}
+
+	record, err := json.Marshal(scheduleRecord{Key: name, Value: data, At: at.UTC(), Meta: changeMeta, Principal: principal})
+	if err != nil {
+		return "", fmt.Errorf("marshal error: %w", err)
+	}
+
+	// id сортируется по времени применения
+	id := fmt.Sprintf("%019d-%08x", at.UnixNano(), rand.Uint32())
+	if _, err = rtc.client.Put(ctx, rtc.scheduleKey(id), string(record)); err != nil {
+		return "", fmt.Errorf("etcd put failed: %w", err)
+	}
+
+	return id, nil
+}
+
+// CancelSchedule отменяет ещё не применённое изменение
+func (rtc *RealTimeConfig) CancelSchedule(ctx context.Context, id string) error {
+	key := rtc.scheduleKey(id)
+	resp, err := rtc.client.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("etcd get failed: %w", err)
+	}
+	if len(resp.Kvs) == 0 {
+		return fmt.Errorf("%w: %s", ErrScheduleNotFound, id)
+	}
+
+	_, record, err := rtc.parseSchedule(resp.Kvs[0])
+	if err != nil {
+		return err
+	}
+	if err = rtc.authorize(ctx, record.Key, OpSchedule, nil); err != nil {
+		return err
+	}
+
+	txnResp, err := rtc.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("etcd delete failed: %w", err)
+	}
+	if !txnResp.Succeeded {
+		return fmt.Errorf("%w: %s", ErrScheduleNotFound, id)
+	}
+
+	return nil
+}
+
+// ListSchedules возвращает ожидающие изменения в порядке применения
+func (rtc *RealTimeConfig) ListSchedules(ctx context.Context) ([]ScheduledChange, error) {
+	resp, err := rtc.client.Get(ctx, rtc.scheduleKey(""), clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	changes := make([]ScheduledChange, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		id, record, err := rtc.parseSchedule(kv)
+		if err != nil {
+			return nil, err
+		}
+
+		change := ScheduledChange{ID: id, Key: record.Key, At: record.At}
+		if meta, ok := rtc.schema[record.Key]; ok && meta.Secret {
+			change.Value = RedactedValue
+		} else if ok {
+			if change.Value, err = rtc.decodeField(ctx, meta, record.Value); err != nil {
+				return nil, fmt.Errorf("unmarshal failed for schedule %s: %w", id, err)
+			}
+		} else {
+			change.Value = string(record.Value)
+		}
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].At.Before(changes[j].At)
+	})
+
+	return changes, nil
+}
+
+// runScheduler участвует в выборах лидера и, став лидером, применяет наступившие изменения
+func (rtc *RealTimeConfig) runScheduler(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := rtc.leadScheduler(ctx)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("Scheduler stopped: %v", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(rtc.opts.scheduleInterval):
+		}
+	}
+}
+
+func (rtc *RealTimeConfig) leadScheduler(ctx context.Context) error {
+	session, err := concurrency.NewSession(rtc.client, concurrency.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("session failed: %w", err)
+	}
+	defer session.Close()
+
+	election := concurrency.NewElection(session, rtc.prefix+"/"+schedulerElector)
+	if err = election.Campaign(ctx, rtc.opts.instanceID); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("campaign failed: %w", err)
+	}
+
+	ticker := time.NewTicker(rtc.opts.scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		if err = rtc.applyDueSchedules(ctx, election); err != nil {
+			log.Printf("Failed to apply scheduled changes: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-session.Done():
+			return errSessionExpired
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyDueSchedules применяет наступившие изменения. Значение записывается через setMany
+// с проверкой прав и аудитом от имени автора изменения, а удаление изменения идёт в той же
+// транзакции при условии, что этот экземпляр всё ещё лидер, поэтому каждое изменение
+// применяется ровно один раз. Изменение, которое не применится и при повторе, удаляется
+// с записью в prefix/_audit/schedules/<id>.
+func (rtc *RealTimeConfig) applyDueSchedules(ctx context.Context, election *concurrency.Election) error {
+	resp, err := rtc.client.Get(ctx, rtc.scheduleKey(""), clientv3.WithPrefix())
+	if err != nil {
+		return fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	now := time.Now()
+	for _, kv := range resp.Kvs {
+		cmps := []clientv3.Cmp{
+			clientv3.Compare(clientv3.ModRevision(string(kv.Key)), "=", kv.ModRevision),
+			clientv3.Compare(clientv3.CreateRevision(election.Key()), "=", election.Rev()),
+		}
+
+		failure := FailedSchedule{ID: strings.TrimPrefix(string(kv.Key), rtc.scheduleKey(""))}
+		id, record, err := rtc.parseSchedule(kv)
+		if err == nil {
+			if record.At.After(now) {
+				continue
+			}
+			failure = FailedSchedule{ID: id, Key: record.Key, At: record.At, Meta: record.Meta}
+			err = rtc.applySchedule(ctx, kv, record, cmps)
+		} else {
+			err = fmt.Errorf("%w: %w", errScheduleFailed, err)
+		}
+
+		switch {
+		case err == nil:
+			log.Printf("Scheduled change applied: %s (%s)", record.Key, id)
+		case errors.Is(err, errTxnConflict):
+		case !errors.Is(err, errScheduleFailed):
+			log.Printf("Failed to apply scheduled change %s: %v", failure.ID, err)
+		default:
+			log.Printf("Scheduled change %s rejected: %v", failure.ID, err)
+			failure.Error = err.Error()
+			if err = rtc.dropSchedule(ctx, string(kv.Key), failure, cmps); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// applySchedule записывает значение изменения. Ошибки, которые повторятся при
+// следующей попытке, оборачиваются в errScheduleFailed.
+func (rtc *RealTimeConfig) applySchedule(ctx context.Context, kv *mvccpb.KeyValue, record scheduleRecord, cmps []clientv3.Cmp) error {
+	meta, ok := rtc.schema[record.Key]
+	if !ok {
+		return fmt.Errorf("%w: unknown config field: %s", errScheduleFailed, record.Key)
+	}
+
+	val, err := rtc.decodeField(ctx, meta, record.Value)
+	if err != nil {
+		return fmt.Errorf("%w: unmarshal failed for field %s: %w", errScheduleFailed, record.Key, err)
+	}
+
+	// поле могло получить approval:"required" после планирования, а запись в prefix/_schedule
+	// в обход Schedule не должна давать прав, которых нет у автора
+	if err = rtc.requireNoApproval(record.Key, OpSchedule); err != nil {
+		return fmt.Errorf("%w: %w", errScheduleFailed, err)
+	}
+	principal := record.Principal
+	if principal.Name == "" {
+		principal.Name = record.Meta.Author
+	}
+
+	applyCtx := WithChangeMeta(WithPrincipal(ctx, principal), record.Meta)
+	_, err = rtc.setManyIf(applyCtx, map[ConfigName]any{record.Key: val}, OpSchedule,
+		cmps, clientv3.OpDelete(string(kv.Key)))
+	if errors.Is(err, ErrValidation) || errors.Is(err, ErrAccessDenied) || errors.Is(err, ErrApprovalRequired) {
+		return fmt.Errorf("%w: %w", errScheduleFailed, err)
+	}
+
+	return err
+}
+
+// dropSchedule удаляет неприменимое изменение и записывает причину в аудит
+func (rtc *RealTimeConfig) dropSchedule(ctx context.Context, key string, failure FailedSchedule, cmps []clientv3.Cmp) error {
+	failure.Time = time.Now().UTC()
+	data, err := json.Marshal(failure)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	_, err = rtc.client.Txn(ctx).
+		If(cmps...).
+		Then(clientv3.OpDelete(key), clientv3.OpPut(rtc.failedScheduleKey(failure.ID), string(data))).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("schedule transaction failed: %w", err)
+	}
+
+	return nil
+}
+
+func (rtc *RealTimeConfig) parseSchedule(kv *mvccpb.KeyValue) (string, scheduleRecord, error) {
+	id := strings.TrimPrefix(string(kv.Key), rtc.scheduleKey(""))
+
+	var record scheduleRecord
+	if err := json.Unmarshal(kv.Value, &record); err != nil {
+		return "", scheduleRecord{}, fmt.Errorf("unmarshal failed for schedule %s: %w", id, err)
+	}
+
+	return id, record, nil
+}
+
+func (rtc *RealTimeConfig) scheduleKey(id string) string {
+	return rtc.prefix + "/" + scheduleDir + "/" + id
+}
+
+func (rtc *RealTimeConfig) failedScheduleKey(id string) string {
+	return rtc.prefix + "/" + failedSchedulesDir + "/" + id
+}
@@ -0,0 +1,190 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealTimeConfig_Schedule(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+	client := srv.Client
+
+	prefix := "/test/config/schedule"
+
+	type Config struct {
+		RateLimit int `etcd:"rate_limit" validate:"min=1"`
+		Limit     int `etcd:"limit" approval:"required"`
+	}
+
+	// инвариант проверяется только при применении изменения
+	belowCap := WithValidator(func(cfg any) error {
+		if cfg.(*Config).RateLimit > 1000 {
+			return errors.New("rate_limit exceeds cap")
+		}
+		return nil
+	})
+
+	cfgs := []*Config{{RateLimit: 100}, {RateLimit: 100}}
+	var rtcs []*RealTimeConfig
+	for i, cfg := range cfgs {
+		rtc, err := NewRealTimeConfig(ctx, client, prefix, cfg,
+			WithScheduler(20*time.Millisecond), WithInstanceID(string(rune('a'+i))), belowCap)
+		require.NoError(t, err)
+		rtcs = append(rtcs, rtc)
+	}
+	rtc := rtcs[0]
+
+	version := func() int64 {
+		resp, err := client.Get(ctx, prefix+"/rate_limit")
+		require.NoError(t, err)
+		require.Len(t, resp.Kvs, 1)
+		return resp.Kvs[0].Version
+	}
+
+	t.Run("Applied once", func(t *testing.T) {
+		before := version()
+
+		alice := WithChangeMeta(WithPrincipal(ctx, Principal{Name: "alice"}), ChangeMeta{Reason: "sale"})
+		sale, err := rtc.Schedule(alice, "rate_limit", 500, time.Now().Add(200*time.Millisecond))
+		require.NoError(t, err)
+		revert, err := rtc.Schedule(ctx, "rate_limit", 100, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+
+		changes, err := rtc.ListSchedules(ctx)
+		require.NoError(t, err)
+		require.Len(t, changes, 2)
+		assert.Equal(t, sale, changes[0].ID)
+		assert.Equal(t, 500, changes[0].Value)
+		assert.Equal(t, revert, changes[1].ID)
+
+		// запись значения и удаление изменения происходят в одной транзакции, после неё
+		// ни один планировщик не применит изменение повторно
+		key := rtc.scheduleKey(sale)
+		srv.Await(t, key, func(kvs map[string][]byte) bool {
+			return kvs[key] == nil
+		})
+		for _, r := range rtcs {
+			srv.Sync(t, r)
+			v, err := r.Value("rate_limit")
+			require.NoError(t, err)
+			assert.Equal(t, 500, v)
+		}
+		assert.Equal(t, before+1, version())
+
+		history, err := rtc.GetKeyHistory(ctx, "rate_limit", 0, 1)
+		require.NoError(t, err)
+		require.NotNil(t, history[0].Meta)
+		assert.Equal(t, "alice", history[0].Meta.Author)
+		assert.Equal(t, "sale", history[0].Meta.Reason)
+		assert.Equal(t, OpSchedule, history[0].Meta.Operation)
+
+		changes, err = rtc.ListSchedules(ctx)
+		require.NoError(t, err)
+		require.Len(t, changes, 1)
+		assert.Equal(t, revert, changes[0].ID)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		changes, err := rtc.ListSchedules(ctx)
+		require.NoError(t, err)
+
+		for _, c := range changes {
+			require.NoError(t, rtc.CancelSchedule(ctx, c.ID))
+		}
+		assert.ErrorIs(t, rtc.CancelSchedule(ctx, changes[0].ID), ErrScheduleNotFound)
+
+		changes, err = rtc.ListSchedules(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+	})
+
+	t.Run("Invalid value", func(t *testing.T) {
+		_, err := rtc.Schedule(ctx, "rate_limit", 0, time.Now())
+		assert.ErrorIs(t, err, ErrValidation)
+
+		_, err = rtc.Schedule(ctx, "unknown", 1, time.Now())
+		assert.Error(t, err)
+	})
+
+	t.Run("Rejected at apply", func(t *testing.T) {
+		before := version()
+
+		id, err := rtc.Schedule(ctx, "rate_limit", 5000, time.Now())
+		require.NoError(t, err)
+
+		key := rtc.scheduleKey(id)
+		srv.Await(t, key, func(kvs map[string][]byte) bool {
+			return kvs[key] == nil
+		})
+		assert.Equal(t, before, version())
+		assert.Equal(t, 500, cfgs[0].RateLimit)
+
+		failure := failedSchedule(t, rtc, id)
+		assert.Equal(t, ConfigName("rate_limit"), failure.Key)
+		assert.Contains(t, failure.Error, "rate_limit exceeds cap")
+	})
+
+	t.Run("Not applicable", func(t *testing.T) {
+		// записи, которые не применятся и при повторе, удаляются вместо бесконечных попыток
+		records := map[string]string{
+			"unknown": `{"key": "unknown", "value": 1, "at": "2000-01-01T00:00:00Z"}`,
+			"decode":  `{"key": "rate_limit", "value": "fast", "at": "2000-01-01T00:00:00Z"}`,
+			"garbage": `not json`,
+		}
+		for id, record := range records {
+			srv.PutRaw(t, rtc.scheduleKey(id), record)
+		}
+		for id := range records {
+			key := rtc.scheduleKey(id)
+			srv.Await(t, key, func(kvs map[string][]byte) bool {
+				return kvs[key] == nil
+			})
+			assert.NotEmpty(t, failedSchedule(t, rtc, id).Error, id)
+		}
+		assert.Equal(t, 500, cfgs[0].RateLimit)
+	})
+
+	t.Run("Approval required at apply", func(t *testing.T) {
+		// запись в обход Schedule не обходит одобрение
+		srv.PutRaw(t, rtc.scheduleKey("raw"), `{"key": "limit", "value": 5, "at": "2000-01-01T00:00:00Z", "meta": {"author": "mallory"}}`)
+
+		key := rtc.scheduleKey("raw")
+		srv.Await(t, key, func(kvs map[string][]byte) bool {
+			return kvs[key] == nil
+		})
+		failure := failedSchedule(t, rtc, "raw")
+		assert.Contains(t, failure.Error, ErrApprovalRequired.Error())
+		assert.Equal(t, "mallory", failure.Meta.Author)
+
+		proposals, err := rtc.ListProposals(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, proposals)
+		limit, err := rtc.Get(ctx, "limit")
+		require.NoError(t, err)
+		assert.Equal(t, 0, limit)
+	})
+}
+
+func failedSchedule(t *testing.T, rtc *RealTimeConfig, id string) FailedSchedule {
+	t.Helper()
+
+	resp, err := rtc.client.Get(context.Background(), rtc.failedScheduleKey(id))
+	require.NoError(t, err)
+	require.Len(t, resp.Kvs, 1)
+
+	var failure FailedSchedule
+	require.NoError(t, json.Unmarshal(resp.Kvs[0].Value, &failure))
+	assert.Equal(t, id, failure.ID)
+
+	return failure
+}
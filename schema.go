@@ -0,0 +1,184 @@
+package konfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// FlagSecret помечает поля с тегом secret:"true", значения которых шифруются
+const FlagSecret = "secret"
+
+// FieldInfo публичное описание поля конфига
+type FieldInfo struct {
+	Name        ConfigName `json:"name"`
+	Type        string     `json:"type"`
+	Default     any        `json:"default,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Rules       []Rule     `json:"rules,omitempty"`
+	Flags       []string   `json:"flags,omitempty"`
+	Owner       string     `json:"owner,omitempty"`
+	Roles       []string   `json:"roles,omitempty"`
+}
+
+// Schema возвращает описание всех полей конфига, отсортированное по имени
+func (rtc *RealTimeConfig) Schema() []FieldInfo {
+	fields := make([]FieldInfo, 0, len(rtc.schema))
+	for name := range rtc.schema {
+		fields = append(fields, rtc.fieldInfo(name))
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+
+	return fields
+}
+
+func (rtc *RealTimeConfig) fieldInfo(name ConfigName) FieldInfo {
+	meta := rtc.schema[name]
+	info := FieldInfo{
+		Name:        name,
+		Type:        meta.Type.String(),
+		Default:     rtc.defaults[name],
+		Description: meta.Description,
+		Rules:       meta.Rules,
+		Flags:       meta.Flags,
+		Owner:       meta.Owner,
+		Roles:       meta.Roles,
+	}
+	if meta.Secret {
+		info.Default = nil
+	}
+
+	return info
+}
+
+// JSONSchema возвращает JSON Schema документа конфига в формате Export
+func (rtc *RealTimeConfig) JSONSchema() ([]byte, error) {
+	properties := make(map[string]any, len(rtc.schema))
+	for _, f := range rtc.Schema() {
+		prop := typeSchema(rtc.schema[f.Name].Type)
+		if f.Description != "" {
+			prop["description"] = f.Description
+		}
+		if f.Default != nil {
+			prop["default"] = f.Default
+		}
+		applyRules(prop, rtc.schema[f.Name].Type, f.Rules)
+
+		properties[string(f.Name)] = prop
+	}
+
+	return json.MarshalIndent(map[string]any{
+		"$schema":              jsonSchemaDraft,
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}, "", "  ")
+}
+
+// typeSchema сопоставляет тип Go с типом JSON Schema
+func typeSchema(t reflect.Type) map[string]any {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return map[string]any{
+			"type":        []string{"integer", "string"},
+			"description": "nanoseconds or a Go duration string such as \"1m30s\"",
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = typeSchema(field.Type)
+		}
+		return map[string]any{"type": "object", "properties": properties}
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	default:
+		return map[string]any{}
+	}
+}
+
+// applyRules переносит правила валидации в ключевые слова JSON Schema
+func applyRules(prop map[string]any, t reflect.Type, rules []Rule) {
+	for _, rule := range rules {
+		switch rule.Name {
+		case "min", "max":
+			bound, err := ruleBound(rule.Arg, t)
+			if err != nil {
+				continue
+			}
+			prop[boundKeyword(rule.Name, t)] = bound
+		case "oneof":
+			var enum []any
+			for _, v := range strings.Fields(rule.Arg) {
+				enum = append(enum, enumValue(v, t))
+			}
+			prop["enum"] = enum
+		}
+	}
+}
+
+func boundKeyword(rule string, t reflect.Type) string {
+	suffix := "imum"
+	switch t.Kind() {
+	case reflect.String:
+		suffix = "Length"
+	case reflect.Slice:
+		suffix = "Items"
+	case reflect.Map:
+		suffix = "Properties"
+	}
+
+	if rule == "min" {
+		return "min" + suffix
+	}
+	return "max" + suffix
+}
+
+func enumValue(v string, t reflect.Type) any {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
@@ -0,0 +1,79 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealTimeConfig_Schema(t *testing.T) {
+	ctx := context.Background()
+	client := konfigtest.New(t).Client
+
+	prefix := "/test/config/schema"
+
+	type Config struct {
+		Retries int           `etcd:"retries" desc:"number of attempts" validate:"min=1,max=10"`
+		Mode    string        `etcd:"mode" validate:"oneof=dev prod"`
+		Timeout time.Duration `etcd:"timeout" validate:"min=100ms"`
+	}
+
+	cfg := &Config{Retries: 3, Mode: "dev", Timeout: time.Second}
+	rtc, err := NewRealTimeConfig(ctx, client, prefix, cfg)
+	require.NoError(t, err)
+
+	t.Run("Introspection", func(t *testing.T) {
+		fields := rtc.Schema()
+		require.Len(t, fields, 3)
+
+		assert.Equal(t, FieldInfo{Name: "mode", Type: "string", Default: "dev",
+			Rules: []Rule{{Name: "oneof", Arg: "dev prod"}}}, fields[0])
+		assert.Equal(t, FieldInfo{Name: "retries", Type: "int", Default: 3, Description: "number of attempts",
+			Rules: []Rule{{Name: "min", Arg: "1"}, {Name: "max", Arg: "10"}}}, fields[1])
+		assert.Equal(t, "time.Duration", fields[2].Type)
+	})
+
+	t.Run("JSON Schema", func(t *testing.T) {
+		data, err := rtc.JSONSchema()
+		require.NoError(t, err)
+
+		var doc struct {
+			Properties map[string]map[string]any `json:"properties"`
+		}
+		require.NoError(t, json.Unmarshal(data, &doc))
+
+		retries := doc.Properties["retries"]
+		assert.Equal(t, "integer", retries["type"])
+		assert.Equal(t, "number of attempts", retries["description"])
+		assert.Equal(t, float64(1), retries["minimum"])
+		assert.Equal(t, float64(10), retries["maximum"])
+		assert.Equal(t, []any{"dev", "prod"}, doc.Properties["mode"]["enum"])
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		err := rtc.Set(ctx, "retries", 11)
+		assert.ErrorIs(t, err, ErrValidation)
+
+		err = rtc.Set(ctx, "mode", "staging")
+		assert.ErrorIs(t, err, ErrValidation)
+
+		err = rtc.Set(ctx, "timeout", time.Millisecond)
+		assert.ErrorIs(t, err, ErrValidation)
+
+		require.NoError(t, rtc.Set(ctx, "retries", 5))
+		assert.Equal(t, 5, cfg.Retries)
+	})
+
+	t.Run("Invalid rules", func(t *testing.T) {
+		type BadConfig struct {
+			Enabled bool `etcd:"enabled" validate:"min=1"`
+		}
+		_, err := NewRealTimeConfig(ctx, client, prefix+"/bad", &BadConfig{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,471 @@
+package konfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// RedactedValue подставляется вместо значений секретных полей в истории, логах и экспорте
+	RedactedValue = "<redacted>"
+
+	// envelopeVersion привязывает шифротекст к полю: prefix/<key> передаётся в AES-GCM как
+	// дополнительные данные, поэтому значение нельзя скопировать в другое секретное поле.
+	envelopeVersion = "aes-gcm-v2"
+	dataKeySize     = 32
+
+	defaultRotationBatch = 64
+	maxRotationPasses    = 5
+)
+
+var (
+	ErrNoKeyProvider = errors.New("secret fields require a key provider")
+	ErrUnknownKeyID  = errors.New("unknown encryption key id")
+	ErrNotEncrypted  = errors.New("secret value is not encrypted")
+)
+
+// KeyProvider выдаёт мастер-ключи AES (16, 24 или 32 байта), которыми шифруются ключи данных
+type KeyProvider interface {
+	// CurrentKey возвращает ключ для шифрования новых значений и его идентификатор
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+	// Key возвращает ключ по идентификатору для расшифровки старых значений
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// envelope хранимое в etcd представление секретного значения: значение шифруется
+// случайным ключом данных, а ключ данных - мастер-ключом провайдера
+type envelope struct {
+	Version string `json:"$enc"`
+	KeyID   string `json:"kid"`
+	DataKey []byte `json:"dek"`
+	Data    []byte `json:"data"`
+}
+
+// sealValue шифрует JSON-значение текущим ключом провайдера. aad - ключ поля prefix/<key>,
+// без него значение не расшифровывается.
+func sealValue(ctx context.Context, kp KeyProvider, aad, plaintext []byte) ([]byte, error) {
+	kid, master, err := kp.CurrentKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get current key: %w", err)
+	}
+
+	dek := make([]byte, dataKeySize)
+	if _, err = io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+
+	data, err := gcmSeal(dek, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := gcmSeal(master, dek, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key: %w", err)
+	}
+
+	return json.Marshal(envelope{
+		Version: envelopeVersion,
+		KeyID:   kid,
+		DataKey: wrapped,
+		Data:    data,
+	})
+}
+
+// openValue расшифровывает значение, записанное sealValue с тем же aad
+func openValue(ctx context.Context, kp KeyProvider, aad, raw []byte) ([]byte, error) {
+	env, ok := parseEnvelope(raw)
+	if !ok {
+		return nil, fmt.Errorf("value is not encrypted")
+	}
+
+	master, err := kp.Key(ctx, env.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	dek, err := gcmOpen(master, env.DataKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key %s: %w", env.KeyID, err)
+	}
+
+	return gcmOpen(dek, env.Data, aad)
+}
+
+// secretAAD дополнительные данные шифрования значений поля name
+func (rtc *RealTimeConfig) secretAAD(name ConfigName) []byte {
+	return []byte(rtc.prefix + "/" + string(name))
+}
+
+// insecureSecret сообщает о секрете, который хранится открытым текстом
+func (rtc *RealTimeConfig) insecureSecret(name ConfigName) {
+	reason := "secret value is stored without encryption, run RotateKeys to encrypt it"
+	log.Printf("Insecure secret %s: %s", name, reason)
+	rtc.publish(Event{Type: EventInsecure, Key: name, Reason: reason})
+}
+
+// RotateKeys перешифровывает все секретные значения под префиксом текущим ключом провайдера,
+// и шифрует значения, записанные открытым текстом до того, как поле пометили secret.
+// Перешифровываются все места хранения секретов: базовые ключи, переопределения всех scope,
+// временные значения и их журнал, значения арендаторов, раскатки, запланированные изменения
+// и предложения, поэтому после ротации старый ключ можно вывести из провайдера.
+// Значения пишутся пачками по batchSize ключей в транзакциях с проверкой ModRevision,
+// поэтому конкурентные записи не перетираются, а lease ключей сохраняется. Старые ключи должны
+// оставаться доступными провайдеру на всех экземплярах, пока ротация не завершится: расшифрованное
+// значение не меняется, и watcher не применяет его повторно. Возвращает количество перешифрованных ключей.
+func (rtc *RealTimeConfig) RotateKeys(ctx context.Context, batchSize int) (int, error) {
+	kp := rtc.opts.keyProvider
+	if kp == nil {
+		return 0, ErrNoKeyProvider
+	}
+	if batchSize <= 0 {
+		batchSize = defaultRotationBatch
+	}
+
+	rotated := 0
+	for pass := 0; pass < maxRotationPasses; pass++ {
+		currentID, _, err := kp.CurrentKey(ctx)
+		if err != nil {
+			return rotated, fmt.Errorf("get current key: %w", err)
+		}
+
+		resp, err := rtc.client.Get(ctx, rtc.prefix+"/", clientv3.WithPrefix())
+		if err != nil {
+			return rotated, fmt.Errorf("etcd get failed: %w", err)
+		}
+
+		var stale []*staleSecret
+		for _, kv := range resp.Kvs {
+			value, changed, err := rtc.resealKey(ctx, string(kv.Key), kv.Value, currentID)
+			if err != nil {
+				return rotated, err
+			}
+			if changed {
+				stale = append(stale, &staleSecret{key: string(kv.Key), value: value, modRev: kv.ModRevision})
+			}
+		}
+		if len(stale) == 0 {
+			return rotated, nil
+		}
+
+		for start := 0; start < len(stale); start += batchSize {
+			end := min(start+batchSize, len(stale))
+			n, err := rtc.rotateBatch(ctx, stale[start:end])
+			if err != nil {
+				return rotated, err
+			}
+			rotated += n
+		}
+	}
+
+	return rotated, fmt.Errorf("key rotation did not converge after %d passes", maxRotationPasses)
+}
+
+// staleSecret ключ с перешифрованным значением value, прочитанный в ревизии modRev
+type staleSecret struct {
+	key    string
+	value  []byte
+	modRev int64
+}
+
+// resealKey перешифровывает секреты в значении ключа key ключом current. Значение секрета
+// лежит как есть в базовых ключах, переопределениях, журнале временных значений и значениях
+// арендаторов, а раскатки, запланированные изменения и предложения хранят его в своих записях.
+// changed=false, если в ключе нет секретов или все они уже зашифрованы ключом current.
+func (rtc *RealTimeConfig) resealKey(ctx context.Context, key string, value []byte, current string) ([]byte, bool, error) {
+	rel := strings.TrimPrefix(key, rtc.prefix+"/")
+	dir, rest, nested := strings.Cut(rel, "/")
+	if !nested {
+		return rtc.resealField(ctx, ConfigName(rel), value, current)
+	}
+
+	// записи, которые не удалось разобрать, не мешают ротации остальных ключей
+	decode := func(record any) bool {
+		if err := json.Unmarshal(value, record); err != nil {
+			log.Printf("Skipping %s in key rotation: %v", key, err)
+			return false
+		}
+		return true
+	}
+
+	var record any
+	var changed bool
+	var err error
+	switch dir {
+	case overridesDir, tenantsDir:
+		// prefix/_overrides/<scope>/<key>, prefix/_tenants/<id>/<key>
+		_, name, _ := strings.Cut(rest, "/")
+		return rtc.resealField(ctx, ConfigName(name), value, current)
+	case temporaryDir:
+		return rtc.resealField(ctx, ConfigName(rest), value, current)
+	case rolloutsDir:
+		var r Rollout
+		if !decode(&r) {
+			return nil, false, nil
+		}
+		r.Value, changed, err = rtc.resealField(ctx, ConfigName(rest), r.Value, current)
+		record = r
+	case scheduleDir:
+		var r scheduleRecord
+		if !decode(&r) {
+			return nil, false, nil
+		}
+		r.Value, changed, err = rtc.resealField(ctx, r.Key, r.Value, current)
+		record = r
+	case proposalsDir:
+		var r proposalRecord
+		if !decode(&r) {
+			return nil, false, nil
+		}
+		for name, raw := range r.Values {
+			var c bool
+			if r.Values[name], c, err = rtc.resealField(ctx, name, raw, current); err != nil {
+				break
+			}
+			changed = changed || c
+		}
+		record = r
+	default:
+		return nil, false, nil
+	}
+	if err != nil || !changed {
+		return nil, false, err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, false, fmt.Errorf("marshal error: %w", err)
+	}
+	return data, true, nil
+}
+
+// resealField перешифровывает значение поля name ключом current, если поле секретное
+func (rtc *RealTimeConfig) resealField(ctx context.Context, name ConfigName, raw []byte, current string) ([]byte, bool, error) {
+	if meta, ok := rtc.schema[name]; !ok || !meta.Secret {
+		return raw, false, nil
+	}
+
+	plaintext := raw
+	if env, encrypted := parseEnvelope(raw); encrypted {
+		if env.KeyID == current {
+			return raw, false, nil
+		}
+		var err error
+		if plaintext, err = openValue(ctx, rtc.opts.keyProvider, rtc.secretAAD(name), raw); err != nil {
+			return nil, false, fmt.Errorf("decrypt %s: %w", name, err)
+		}
+	}
+
+	sealed, err := sealValue(ctx, rtc.opts.keyProvider, rtc.secretAAD(name), plaintext)
+	if err != nil {
+		return nil, false, fmt.Errorf("encrypt %s: %w", name, err)
+	}
+	return sealed, true, nil
+}
+
+// rotateBatch записывает пачку перешифрованных ключей одной транзакцией. При конфликте
+// пачка пропускается и будет обработана на следующем проходе RotateKeys.
+func (rtc *RealTimeConfig) rotateBatch(ctx context.Context, batch []*staleSecret) (int, error) {
+	cmps := make([]clientv3.Cmp, 0, len(batch))
+	ops := make([]clientv3.Op, 0, len(batch))
+	for _, kv := range batch {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(kv.key), "=", kv.modRev))
+		// временные значения и предложения живут на lease, перешифрование его не снимает
+		ops = append(ops, clientv3.OpPut(kv.key, string(kv.value), clientv3.WithIgnoreLease()))
+	}
+
+	txnResp, err := rtc.client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return 0, fmt.Errorf("rotation transaction failed: %w", err)
+	}
+	if !txnResp.Succeeded {
+		return 0, nil
+	}
+
+	return len(batch), nil
+}
+
+func parseEnvelope(raw []byte) (envelope, bool) {
+	var env envelope
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		return env, false
+	}
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return env, false
+	}
+	if env.Version != envelopeVersion {
+		return env, false
+	}
+	return env, true
+}
+
+func gcmSeal(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func gcmOpen(key, sealed, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// FileKeyProvider читает ключи из JSON-файла вида
+//
+//	{"current": "k2", "keys": {"k1": "<base64>", "k2": "<base64>"}}
+type FileKeyProvider struct {
+	path string
+
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// NewFileKeyProvider загружает ключи из файла
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	p := &FileKeyProvider{path: path}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload перечитывает файл ключей
+func (p *FileKeyProvider) Reload() error {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("read key file: %w", err)
+	}
+
+	var f keyFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("parse key file: %w", err)
+	}
+
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		key, err := decodeMasterKey(encoded)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", id, err)
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[f.Current]; !ok {
+		return fmt.Errorf("%w: current key %q is not in the key file", ErrUnknownKeyID, f.Current)
+	}
+
+	p.mu.Lock()
+	p.current, p.keys = f.Current, keys
+	p.mu.Unlock()
+
+	return nil
+}
+
+func (p *FileKeyProvider) CurrentKey(context.Context) (string, []byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *FileKeyProvider) Key(_ context.Context, id string) ([]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, id)
+	}
+	return key, nil
+}
+
+// EnvKeyProvider читает ключи из переменных окружения: <PREFIX>_CURRENT содержит
+// идентификатор текущего ключа, а <PREFIX>_KEY_<ID> - сам ключ в base64
+type EnvKeyProvider struct {
+	prefix string
+}
+
+// NewEnvKeyProvider создаёт провайдер и проверяет, что текущий ключ задан
+func NewEnvKeyProvider(prefix string) (*EnvKeyProvider, error) {
+	p := &EnvKeyProvider{prefix: prefix}
+	if _, _, err := p.CurrentKey(context.Background()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *EnvKeyProvider) CurrentKey(ctx context.Context) (string, []byte, error) {
+	id := os.Getenv(p.prefix + "_CURRENT")
+	if id == "" {
+		return "", nil, fmt.Errorf("%s_CURRENT is not set", p.prefix)
+	}
+
+	key, err := p.Key(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, key, nil
+}
+
+func (p *EnvKeyProvider) Key(_ context.Context, id string) ([]byte, error) {
+	encoded := os.Getenv(p.prefix + "_KEY_" + strings.ToUpper(id))
+	if encoded == "" {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, id)
+	}
+	return decodeMasterKey(encoded)
+}
+
+func decodeMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %w", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("invalid key size %d, expected 16, 24 or 32 bytes", len(key))
+	}
+}
@@ -0,0 +1,377 @@
+package konfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeyFile(t *testing.T, current string, ids ...string) string {
+	t.Helper()
+
+	var keys []string
+	for _, id := range ids {
+		key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte(id[len(id)-1:]), 32))
+		keys = append(keys, `"`+id+`": "`+key+`"`)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	data := `{"current": "` + current + `", "keys": {` + strings.Join(keys, ",") + `}}`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	return path
+}
+
+func TestKeyProviders(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("File provider", func(t *testing.T) {
+		kp, err := NewFileKeyProvider(writeKeyFile(t, "k2", "k1", "k2"))
+		require.NoError(t, err)
+
+		sealed, err := sealValue(ctx, kp, []byte("/app/token"), []byte(`"token"`))
+		require.NoError(t, err)
+		assert.NotContains(t, string(sealed), "token")
+
+		env, ok := parseEnvelope(sealed)
+		require.True(t, ok)
+		assert.Equal(t, "k2", env.KeyID)
+
+		plaintext, err := openValue(ctx, kp, []byte("/app/token"), sealed)
+		require.NoError(t, err)
+		assert.Equal(t, `"token"`, string(plaintext))
+
+		// шифротекст привязан к ключу поля
+		_, err = openValue(ctx, kp, []byte("/app/password"), sealed)
+		assert.Error(t, err)
+
+		_, err = NewFileKeyProvider(writeKeyFile(t, "k3", "k1"))
+		assert.ErrorIs(t, err, ErrUnknownKeyID)
+	})
+
+	t.Run("Env provider", func(t *testing.T) {
+		t.Setenv("TEST_KONFIG_CURRENT", "a1")
+		t.Setenv("TEST_KONFIG_KEY_A1", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 16)))
+
+		kp, err := NewEnvKeyProvider("TEST_KONFIG")
+		require.NoError(t, err)
+
+		sealed, err := sealValue(ctx, kp, []byte("/app/pin"), []byte(`42`))
+		require.NoError(t, err)
+		plaintext, err := openValue(ctx, kp, []byte("/app/pin"), sealed)
+		require.NoError(t, err)
+		assert.Equal(t, `42`, string(plaintext))
+
+		_, err = kp.Key(ctx, "b2")
+		assert.ErrorIs(t, err, ErrUnknownKeyID)
+	})
+}
+
+func TestRealTimeConfig_Secrets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+	client := srv.Client
+
+	prefix := "/test/config/secret"
+
+	type Config struct {
+		APIKey string `etcd:"api_key" secret:"true"`
+		Mode   string `etcd:"mode"`
+	}
+
+	kp, err := NewFileKeyProvider(writeKeyFile(t, "k1", "k1"))
+	require.NoError(t, err)
+
+	_, err = NewRealTimeConfig(ctx, client, prefix, &Config{})
+	require.ErrorIs(t, err, ErrNoKeyProvider)
+
+	cfg := &Config{APIKey: "default-key", Mode: "dev"}
+	rtc, err := NewRealTimeConfig(ctx, client, prefix, cfg, WithKeyProvider(kp))
+	require.NoError(t, err)
+
+	t.Run("Encrypted at rest", func(t *testing.T) {
+		require.NoError(t, rtc.Set(ctx, "api_key", "s3cr3t"))
+
+		resp, err := client.Get(ctx, prefix+"/api_key")
+		require.NoError(t, err)
+		require.Len(t, resp.Kvs, 1)
+		assert.NotContains(t, string(resp.Kvs[0].Value), "s3cr3t")
+
+		val, err := rtc.Get(ctx, "api_key")
+		require.NoError(t, err)
+		assert.Equal(t, "s3cr3t", val)
+	})
+
+	t.Run("Watch decrypts", func(t *testing.T) {
+		other, err := NewRealTimeConfig(ctx, client, prefix, &Config{}, WithKeyProvider(kp), WithoutWatch())
+		require.NoError(t, err)
+		require.NoError(t, other.Set(ctx, "api_key", "rotated"))
+
+		srv.Sync(t, rtc)
+		assert.Equal(t, "rotated", cfg.APIKey)
+	})
+
+	t.Run("Redaction", func(t *testing.T) {
+		history, err := rtc.GetKeyHistory(ctx, "api_key", 0, 10)
+		require.NoError(t, err)
+		require.NotEmpty(t, history)
+		for _, entry := range history {
+			assert.Equal(t, RedactedValue, entry.Value)
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, rtc.Export(ctx, &buf, FormatJSON))
+		assert.Contains(t, buf.String(), RedactedValue)
+		assert.NotContains(t, buf.String(), "rotated")
+
+		exported := buf.String()
+		changes, err := rtc.Import(ctx, &buf, ImportOptions{Format: FormatJSON})
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+
+		val, err := rtc.Get(ctx, "api_key")
+		require.NoError(t, err)
+		assert.Equal(t, "rotated", val)
+
+		// redacted секрет не сбрасывается к значению по умолчанию и при замене
+		changes, err = rtc.Import(ctx, strings.NewReader(exported), ImportOptions{Format: FormatJSON, Mode: ImportReplace})
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+
+		val, err = rtc.Get(ctx, "api_key")
+		require.NoError(t, err)
+		assert.Equal(t, "rotated", val)
+
+		for _, f := range rtc.Schema() {
+			if f.Name == "api_key" {
+				assert.Nil(t, f.Default)
+				assert.Equal(t, []string{FlagSecret}, f.Flags)
+			}
+		}
+	})
+
+	t.Run("Rule errors hide value", func(t *testing.T) {
+		type TokenConfig struct {
+			Token string `etcd:"token" secret:"true" validate:"min=8"`
+		}
+
+		tokenPrefix := prefix + "/rules"
+		tok, err := NewRealTimeConfig(ctx, client, tokenPrefix, &TokenConfig{Token: "long-enough"},
+			WithKeyProvider(kp), WithStatusReporting(5*time.Second), WithInstanceID("node-1"))
+		require.NoError(t, err)
+		events := tok.Subscribe(ctx)
+
+		err = tok.Set(ctx, "token", "hunter2")
+		require.ErrorIs(t, err, ErrValidation)
+		assert.NotContains(t, err.Error(), "hunter2")
+
+		sealed, err := sealValue(ctx, kp, tok.secretAAD("token"), []byte(`"hunter2"`))
+		require.NoError(t, err)
+		konfigtest.WaitApplied(t, tok, srv.PutRaw(t, tokenPrefix+"/token", string(sealed)))
+
+		ev := nextEvent(t, events, EventRejected)
+		assert.Contains(t, ev.Reason, "min=8")
+		assert.NotContains(t, ev.Reason, "hunter2")
+		assert.NotContains(t, tok.Status().Rejections["token"].Error, "hunter2")
+	})
+
+	t.Run("Plaintext written before tagging", func(t *testing.T) {
+		plainPrefix := prefix + "/plaintext"
+		srv.Put(t, plainPrefix+"/api_key", "legacy")
+
+		// без явного разрешения открытый текст не читается
+		_, err := NewRealTimeConfig(ctx, client, plainPrefix, &Config{}, WithKeyProvider(kp))
+		require.ErrorIs(t, err, ErrNotEncrypted)
+
+		plainCfg := &Config{}
+		plain, err := NewRealTimeConfig(ctx, client, plainPrefix, plainCfg, WithKeyProvider(kp), WithPlaintextSecrets())
+		require.NoError(t, err)
+		assert.Equal(t, "legacy", plainCfg.APIKey)
+
+		// открытый текст применяется только для миграции и помечается как небезопасный
+		events := plain.Subscribe(ctx)
+		srv.Push(t, plain, plainPrefix+"/api_key", "legacy")
+		ev := nextEvent(t, events, EventInsecure)
+		assert.Equal(t, ConfigName("api_key"), ev.Key)
+		assert.NotContains(t, ev.Reason, "legacy")
+
+		n, err := plain.RotateKeys(ctx, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		resp, err := client.Get(ctx, plainPrefix+"/api_key")
+		require.NoError(t, err)
+		assert.NotContains(t, string(resp.Kvs[0].Value), "legacy")
+		_, ok := parseEnvelope(resp.Kvs[0].Value)
+		assert.True(t, ok)
+
+		srv.Sync(t, plain)
+		assert.Equal(t, "legacy", plainCfg.APIKey)
+	})
+
+	t.Run("Ciphertext bound to field", func(t *testing.T) {
+		type TwoSecrets struct {
+			APIKey   string `etcd:"api_key" secret:"true"`
+			Password string `etcd:"password" secret:"true"`
+		}
+
+		boundPrefix := prefix + "/bound"
+		boundCfg := &TwoSecrets{APIKey: "api-secret", Password: "db-secret"}
+		bound, err := NewRealTimeConfig(ctx, client, boundPrefix, boundCfg, WithKeyProvider(kp))
+		require.NoError(t, err)
+		events := bound.Subscribe(ctx)
+
+		resp, err := client.Get(ctx, boundPrefix+"/api_key")
+		require.NoError(t, err)
+		konfigtest.WaitApplied(t, bound, srv.PutRaw(t, boundPrefix+"/password", string(resp.Kvs[0].Value)))
+
+		ev := nextEvent(t, events, EventRejected)
+		assert.Equal(t, ConfigName("password"), ev.Key)
+		assert.Contains(t, ev.Reason, "decrypt failed")
+		assert.Equal(t, "db-secret", boundCfg.Password)
+
+		// конверт без дополнительных данных не расшифровывается ни в одном поле
+		_, master, err := kp.CurrentKey(ctx)
+		require.NoError(t, err)
+		dek := bytes.Repeat([]byte{9}, dataKeySize)
+		data, err := gcmSeal(dek, []byte(`"unbound-secret"`), nil)
+		require.NoError(t, err)
+		wrapped, err := gcmSeal(master, dek, nil)
+		require.NoError(t, err)
+		unbound, err := json.Marshal(envelope{Version: envelopeVersion, KeyID: "k1", DataKey: wrapped, Data: data})
+		require.NoError(t, err)
+
+		konfigtest.WaitApplied(t, bound, srv.PutRaw(t, boundPrefix+"/password", string(unbound)))
+		ev = nextEvent(t, events, EventRejected)
+		assert.Equal(t, ConfigName("password"), ev.Key)
+		assert.Contains(t, ev.Reason, "decrypt failed")
+		assert.Equal(t, "db-secret", boundCfg.Password)
+
+		// открытый текст подменил бы секрет без ключа
+		konfigtest.WaitApplied(t, bound, srv.Put(t, boundPrefix+"/password", "forged"))
+		ev = nextEvent(t, events, EventRejected)
+		assert.Equal(t, ConfigName("password"), ev.Key)
+		assert.Contains(t, ev.Reason, ErrNotEncrypted.Error())
+		assert.Equal(t, "db-secret", boundCfg.Password)
+	})
+
+	t.Run("Rotation covers every location", func(t *testing.T) {
+		keyPath := writeKeyFile(t, "k1", "k1")
+		kp, err := NewFileKeyProvider(keyPath)
+		require.NoError(t, err)
+		setKeys := func(current string, ids ...string) {
+			data, err := os.ReadFile(writeKeyFile(t, current, ids...))
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(keyPath, data, 0o600))
+			require.NoError(t, kp.Reload())
+		}
+
+		locPrefix := prefix + "/locations"
+		loc, err := NewRealTimeConfig(ctx, client, locPrefix, &Config{APIKey: "base-secret"},
+			WithKeyProvider(kp), WithOverrideScopes("canary"))
+		require.NoError(t, err)
+		m, err := NewTenantManager(ctx, client, locPrefix, &Config{}, 2, WithKeyProvider(kp))
+		require.NoError(t, err)
+
+		require.NoError(t, loc.SetOverride(ctx, "canary", "api_key", "override-secret"))
+		require.NoError(t, loc.StartRollout(ctx, "api_key", "rollout-secret", 0))
+		require.NoError(t, m.Set(ctx, "acme", "api_key", "tenant-secret"))
+		scheduled, err := loc.Schedule(ctx, "api_key", "scheduled-secret", time.Now().Add(time.Hour))
+		require.NoError(t, err)
+
+		setKeys("k2", "k1", "k2")
+		n, err := loc.RotateKeys(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+
+		// после ротации старый ключ больше не нужен
+		setKeys("k2", "k2")
+		n, err = loc.RotateKeys(ctx, 2)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+
+		fresh, err := NewRealTimeConfig(ctx, client, locPrefix, &Config{},
+			WithKeyProvider(kp), WithOverrideScopes("canary"), WithoutWatch())
+		require.NoError(t, err)
+		val, err := fresh.Value("api_key")
+		require.NoError(t, err)
+		assert.Equal(t, "override-secret", val)
+
+		acme, err := m.ForTenant(ctx, "acme")
+		require.NoError(t, err)
+		val, err = acme.Value("api_key")
+		require.NoError(t, err)
+		assert.Equal(t, "tenant-secret", val)
+
+		decode := func(raw []byte) any {
+			env, ok := parseEnvelope(raw)
+			require.True(t, ok)
+			assert.Equal(t, "k2", env.KeyID)
+			val, err := fresh.decodeField(ctx, fresh.schema["api_key"], raw)
+			require.NoError(t, err)
+			return val
+		}
+
+		rollout, err := fresh.GetRollout(ctx, "api_key")
+		require.NoError(t, err)
+		assert.Equal(t, "rollout-secret", decode(rollout.Value))
+
+		resp, err := client.Get(ctx, fresh.scheduleKey(scheduled))
+		require.NoError(t, err)
+		_, record, err := fresh.parseSchedule(resp.Kvs[0])
+		require.NoError(t, err)
+		assert.Equal(t, "scheduled-secret", decode(record.Value))
+
+		resp, err = client.Get(ctx, locPrefix+"/api_key")
+		require.NoError(t, err)
+		assert.Equal(t, "base-secret", decode(resp.Kvs[0].Value))
+	})
+
+	t.Run("Key rotation", func(t *testing.T) {
+		keyPath := writeKeyFile(t, "k1", "k1")
+		kp, err := NewFileKeyProvider(keyPath)
+		require.NoError(t, err)
+
+		rotCfg := &Config{APIKey: "before-rotation", Mode: "dev"}
+		rotPrefix := prefix + "/rotation"
+		rot, err := NewRealTimeConfig(ctx, client, rotPrefix, rotCfg, WithKeyProvider(kp))
+		require.NoError(t, err)
+
+		n, err := rot.RotateKeys(ctx, 10)
+		require.NoError(t, err)
+		assert.Zero(t, n)
+
+		rotated := writeKeyFile(t, "k2", "k1", "k2")
+		data, err := os.ReadFile(rotated)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(keyPath, data, 0o600))
+		require.NoError(t, kp.Reload())
+
+		n, err = rot.RotateKeys(ctx, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		resp, err := client.Get(ctx, rotPrefix+"/api_key")
+		require.NoError(t, err)
+		env, ok := parseEnvelope(resp.Kvs[0].Value)
+		require.True(t, ok)
+		assert.Equal(t, "k2", env.KeyID)
+
+		val, err := rot.Get(ctx, "api_key")
+		require.NoError(t, err)
+		assert.Equal(t, "before-rotation", val)
+		assert.Equal(t, "before-rotation", rotCfg.APIKey)
+	})
+}
@@ -0,0 +1,239 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const statusDir = ".status"
+
+// statusInterval минимальный интервал между записями статуса
+const statusInterval = time.Second
+
+// InstanceStatus статус экземпляра: до какой ревизии применены изменения
+// и какие значения он отверг. Rejections индексированы ключом относительно префикса.
+// AppliedRevision не включает отложенные WithDebounce и замороженные WithFlapProtection изменения.
+type InstanceStatus struct {
+	InstanceID      string               `json:"instance_id"`
+	AppliedRevision int64                `json:"applied_revision"`
+	Rejections      map[string]Rejection `json:"rejections,omitempty"`
+	UpdatedAt       time.Time            `json:"updated_at"`
+}
+
+// Rejection значение ключа, которое экземпляр не смог применить и оставил прежнее
+type Rejection struct {
+	Key      ConfigName `json:"key"`
+	Scope    string     `json:"scope"`
+	Revision int64      `json:"revision"`
+	Error    string     `json:"error"`
+	Time     time.Time  `json:"time"`
+}
+
+// ListInstanceStatus возвращает статусы экземпляров, публикующих их под префиксом
+func ListInstanceStatus(ctx context.Context, cli *clientv3.Client, prefix string) ([]InstanceStatus, error) {
+	resp, err := cli.Get(ctx, prefix+"/"+statusDir+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	statuses := make([]InstanceStatus, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var status InstanceStatus
+		if err = json.Unmarshal(kv.Value, &status); err != nil {
+			return nil, fmt.Errorf("unmarshal failed for status %s: %w", kv.Key, err)
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].InstanceID < statuses[j].InstanceID
+	})
+
+	return statuses, nil
+}
+
+// Status возвращает статус этого экземпляра
+func (rtc *RealTimeConfig) Status() InstanceStatus {
+	revision := rtc.settledRevision()
+
+	rtc.appliedMu.Lock()
+	defer rtc.appliedMu.Unlock()
+
+	status := InstanceStatus{
+		InstanceID:      rtc.opts.instanceID,
+		AppliedRevision: revision,
+		UpdatedAt:       time.Now().UTC(),
+	}
+	if len(rtc.rejections) > 0 {
+		status.Rejections = make(map[string]Rejection, len(rtc.rejections))
+		for key, r := range rtc.rejections {
+			status.Rejections[key] = r
+		}
+	}
+
+	return status
+}
+
+// reject запоминает значение, которое watch не смог применить, и сообщает о нём подписчикам
+func (rtc *RealTimeConfig) reject(key string, c change, err error) {
+	rtc.publish(Event{Type: EventRejected, Key: c.name, Scope: c.scope, Revision: c.modRev, Reason: err.Error()})
+
+	rtc.appliedMu.Lock()
+	rtc.rejections[strings.TrimPrefix(key, rtc.prefix+"/")] = Rejection{
+		Key:      c.name,
+		Scope:    c.scope,
+		Revision: c.modRev,
+		Error:    err.Error(),
+		Time:     time.Now().UTC(),
+	}
+	rtc.appliedMu.Unlock()
+
+	rtc.statusChanged()
+}
+
+// accept снимает отказ, когда ключ получил применимое значение или был удалён
+func (rtc *RealTimeConfig) accept(key string) {
+	rel := strings.TrimPrefix(key, rtc.prefix+"/")
+
+	rtc.appliedMu.Lock()
+	_, ok := rtc.rejections[rel]
+	delete(rtc.rejections, rel)
+	rtc.appliedMu.Unlock()
+
+	if ok {
+		rtc.statusChanged()
+	}
+}
+
+// touchStatus отмечает, что следующее продвижение применённой ревизии нужно опубликовать
+func (rtc *RealTimeConfig) touchStatus() {
+	rtc.appliedMu.Lock()
+	rtc.statusDirty = true
+	rtc.appliedMu.Unlock()
+}
+
+func (rtc *RealTimeConfig) statusChanged() {
+	if rtc.statusCh == nil {
+		return
+	}
+	select {
+	case rtc.statusCh <- struct{}{}:
+	default:
+	}
+}
+
+// reportStatus записывает статус в prefix/.status/<instanceID> и регистрацию в
+// prefix/.instances/<instanceID> с привязкой к lease после каждого изменения, но не чаще
+// statusInterval. Если lease пропал, он выдаётся заново.
+// После отмены ctx lease отзывается, и ключи экземпляра сразу удаляются.
+func (rtc *RealTimeConfig) reportStatus(ctx context.Context) {
+	var lease clientv3.LeaseID
+	var leaseLost <-chan struct{}
+	defer func() {
+		if lease == 0 {
+			return
+		}
+		rtc.revokeLease(lease, "instance")
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-rtc.statusCh:
+		case <-leaseLost:
+			lease, leaseLost = 0, nil
+		}
+
+		var err error
+		if lease == 0 {
+			lease, leaseLost, err = rtc.grantLease(ctx, rtc.opts.instanceTTL)
+		}
+		if err == nil {
+			err = rtc.putStatus(ctx, lease)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to report status: %v", err)
+			rtc.statusChanged()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(statusInterval):
+		}
+	}
+}
+
+func (rtc *RealTimeConfig) putStatus(ctx context.Context, lease clientv3.LeaseID) error {
+	var ops []clientv3.Op
+	if rtc.opts.statusReport {
+		data, err := json.Marshal(rtc.Status())
+		if err != nil {
+			return fmt.Errorf("marshal error: %w", err)
+		}
+		key := rtc.prefix + "/" + statusDir + "/" + rtc.opts.instanceID
+		ops = append(ops, clientv3.OpPut(key, string(data), clientv3.WithLease(lease)))
+	}
+	if rtc.opts.register {
+		instance, err := rtc.instance()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(instance)
+		if err != nil {
+			return fmt.Errorf("marshal error: %w", err)
+		}
+		key := rtc.prefix + "/" + instancesDir + "/" + rtc.opts.instanceID
+		ops = append(ops, clientv3.OpPut(key, string(data), clientv3.WithLease(lease)))
+	}
+
+	if _, err := rtc.client.Txn(ctx).Then(ops...).Commit(); err != nil {
+		return fmt.Errorf("etcd txn failed: %w", err)
+	}
+
+	return nil
+}
+
+// grantLease выдаёт lease на ttl, округлённый вверх до секунды, и продлевает его;
+// lost закрывается, когда продление прекратилось
+func (rtc *RealTimeConfig) grantLease(ctx context.Context, ttl time.Duration) (clientv3.LeaseID, <-chan struct{}, error) {
+	resp, err := rtc.client.Grant(ctx, int64(math.Ceil(ttl.Seconds())))
+	if err != nil {
+		return 0, nil, fmt.Errorf("etcd lease grant failed: %w", err)
+	}
+
+	ka, err := rtc.client.KeepAlive(ctx, resp.ID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("etcd lease keepalive failed: %w", err)
+	}
+
+	lost := make(chan struct{})
+	go func() {
+		defer close(lost)
+		for range ka {
+		}
+	}()
+
+	return resp.ID, lost, nil
+}
+
+// revokeLease отзывает lease, ключи которого больше не нужны. Вызывается и после отмены
+// контекста процесса, поэтому использует собственный.
+func (rtc *RealTimeConfig) revokeLease(lease clientv3.LeaseID, what string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := rtc.client.Revoke(ctx, lease); err != nil {
+		log.Printf("Failed to revoke %s lease: %v", what, err)
+	}
+}
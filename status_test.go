@@ -0,0 +1,77 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealTimeConfig_StatusReporting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/status"
+
+	type Config struct {
+		Port    int    `etcd:"port" validate:"min=1,max=65535"`
+		Mode    string `etcd:"mode"`
+		Retries int    `etcd:"retries"`
+	}
+
+	cfg := &Config{Port: 8080, Mode: "prod", Retries: 3}
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, cfg, WithInstanceID("node-1"), WithStatusReporting(5*time.Second))
+	require.NoError(t, err)
+
+	srv.Push(t, rtc, prefix+"/port", 70000)
+	rev := srv.PutRaw(t, prefix+"/retries", "many")
+	konfigtest.WaitApplied(t, rtc, rev)
+	assert.Equal(t, 8080, cfg.Port)
+	assert.Equal(t, 3, cfg.Retries)
+
+	status := rtc.Status()
+	assert.Equal(t, "node-1", status.InstanceID)
+	// запись самого статуса тоже может сдвинуть ревизию
+	assert.GreaterOrEqual(t, status.AppliedRevision, rev)
+	require.Len(t, status.Rejections, 2)
+	assert.Equal(t, ConfigName("port"), status.Rejections["port"].Key)
+	assert.Equal(t, BaseScope, status.Rejections["port"].Scope)
+	assert.Contains(t, status.Rejections["port"].Error, "greater than 65535")
+	assert.Equal(t, rev, status.Rejections["retries"].Revision)
+
+	statusDir := prefix + "/" + statusDir + "/"
+	srv.Await(t, statusDir, func(kvs map[string][]byte) bool {
+		var s InstanceStatus
+		return len(kvs) == 1 && json.Unmarshal(kvs[statusDir+"node-1"], &s) == nil &&
+			s.AppliedRevision >= rev && len(s.Rejections) == 2
+	})
+
+	// применимое значение снимает отказ
+	srv.Push(t, rtc, prefix+"/port", 9090)
+	rev = srv.Put(t, prefix+"/retries", 5)
+	konfigtest.WaitApplied(t, rtc, rev)
+	assert.Equal(t, 9090, cfg.Port)
+	assert.Empty(t, rtc.Status().Rejections)
+
+	srv.Await(t, statusDir, func(kvs map[string][]byte) bool {
+		var s InstanceStatus
+		return len(kvs) == 1 && json.Unmarshal(kvs[statusDir+"node-1"], &s) == nil &&
+			s.AppliedRevision >= rev && len(s.Rejections) == 0
+	})
+	statuses, err := ListInstanceStatus(ctx, srv.Client, prefix)
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "node-1", statuses[0].InstanceID)
+
+	// статус остановленного экземпляра удаляется вместе с lease
+	cancel()
+	srv.Await(t, statusDir, func(kvs map[string][]byte) bool {
+		return len(kvs) == 0
+	})
+}
@@ -0,0 +1,102 @@
+package konfig
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	temporaryDir = "_temporary"
+
+	// TemporaryScope scope временных значений, записанных через SetWithTTL.
+	// Имеет наивысший приоритет у всех экземпляров.
+	TemporaryScope = "_temporary"
+)
+
+// SetWithTTL временно переопределяет значение поля на всех экземплярах. Переопределение
+// привязано к lease etcd: по истечении ttl ключ удаляется и экземпляры возвращаются
+// к прежнему значению. Каждая запись сохраняется в журнал prefix/_temporary/<key>
+// и попадает в историю с признаком Temporary.
+func (rtc *RealTimeConfig) SetWithTTL(ctx context.Context, name ConfigName, value any, ttl time.Duration) error {
+	meta, ok := rtc.schema[name]
+	if !ok {
+		return fmt.Errorf("unknown config field: %s", name)
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be positive, got %v", ttl)
+	}
+
+	convertedVal, err := convertType(value, meta.Type)
+	if err != nil {
+		return fmt.Errorf("type conversion failed for field %s: %w", name, err)
+	}
+	if err = checkRules(name, meta, convertedVal); err != nil {
+		return err
+	}
+	if err = rtc.authorize(ctx, name, OpTemporary, convertedVal); err != nil {
+		return err
+	}
+	if err = rtc.requireNoApproval(name, OpTemporary); err != nil {
+		return err
+	}
+	if err = rtc.validateChanges([]change{{name: name, field: meta, scope: TemporaryScope, value: convertedVal, present: true}}); err != nil {
+		return err
+	}
+
+	data, err := rtc.encodeField(ctx, meta, convertedVal)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	lease, err := rtc.client.Grant(ctx, int64(math.Ceil(ttl.Seconds())))
+	if err != nil {
+		return fmt.Errorf("lease grant failed: %w", err)
+	}
+	// без записи значения lease никому не нужен, в том числе после отмены ctx
+	defer func() {
+		if err != nil {
+			rtc.revokeLease(lease.ID, "temporary value")
+		}
+	}()
+
+	_, err = rtc.client.Txn(ctx).
+		Then(
+			clientv3.OpPut(rtc.overrideKey(TemporaryScope, name), string(data), clientv3.WithLease(lease.ID)),
+			clientv3.OpPut(rtc.temporaryLogKey(name), string(data)),
+		).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("etcd put failed: %w", err)
+	}
+
+	rtc.applyValue(name, meta, TemporaryScope, convertedVal, true)
+
+	return nil
+}
+
+// ClearTemporary досрочно снимает временное значение поля
+func (rtc *RealTimeConfig) ClearTemporary(ctx context.Context, name ConfigName) error {
+	meta, ok := rtc.schema[name]
+	if !ok {
+		return fmt.Errorf("unknown config field: %s", name)
+	}
+	if err := rtc.authorize(ctx, name, OpTemporary, nil); err != nil {
+		return err
+	}
+
+	if _, err := rtc.client.Delete(ctx, rtc.overrideKey(TemporaryScope, name)); err != nil {
+		return fmt.Errorf("etcd delete failed: %w", err)
+	}
+
+	rtc.applyValue(name, meta, TemporaryScope, nil, false)
+
+	return nil
+}
+
+func (rtc *RealTimeConfig) temporaryLogKey(name ConfigName) string {
+	return rtc.prefix + "/" + temporaryDir + "/" + string(name)
+}
@@ -0,0 +1,129 @@
+package konfig
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestRealTimeConfig_SetWithTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+	client := srv.Client
+
+	prefix := "/test/config/temporary"
+
+	type Config struct {
+		LogLevel string `etcd:"log_level" validate:"oneof=debug info warn"`
+	}
+
+	cfg := &Config{LogLevel: "info"}
+	rtc, err := NewRealTimeConfig(ctx, client, prefix, cfg, WithOverrideScopes(HostScope()))
+	require.NoError(t, err)
+
+	otherCfg := &Config{LogLevel: "info"}
+	other, err := NewRealTimeConfig(ctx, client, prefix, otherCfg)
+	require.NoError(t, err)
+
+	t.Run("Expires", func(t *testing.T) {
+		require.NoError(t, rtc.SetOverride(ctx, HostScope(), "log_level", "warn"))
+		require.NoError(t, rtc.SetWithTTL(ctx, "log_level", "debug", time.Second))
+
+		resolved, err := rtc.Resolve(ctx, "log_level")
+		require.NoError(t, err)
+		assert.Equal(t, Resolved{Value: "debug", Scope: TemporaryScope}, resolved)
+
+		srv.Sync(t, other)
+		val, err := other.Value("log_level")
+		require.NoError(t, err)
+		assert.Equal(t, "debug", val)
+
+		key := rtc.overrideKey(TemporaryScope, "log_level")
+		srv.Await(t, key, func(kvs map[string][]byte) bool {
+			return kvs[key] == nil
+		})
+		srv.Sync(t, rtc)
+		val, err = rtc.Value("log_level")
+		require.NoError(t, err)
+		assert.Equal(t, "warn", val)
+
+		srv.Sync(t, other)
+		val, err = other.Value("log_level")
+		require.NoError(t, err)
+		assert.Equal(t, "info", val)
+	})
+
+	t.Run("Cleared early", func(t *testing.T) {
+		require.NoError(t, rtc.SetWithTTL(ctx, "log_level", "debug", time.Hour))
+		require.NoError(t, rtc.ClearTemporary(ctx, "log_level"))
+
+		val, err := rtc.Value("log_level")
+		require.NoError(t, err)
+		assert.Equal(t, "warn", val)
+	})
+
+	t.Run("History", func(t *testing.T) {
+		history, err := rtc.GetKeyHistory(ctx, "log_level", 0, 0)
+		require.NoError(t, err)
+
+		var temporary, permanent int
+		for _, entry := range history {
+			if entry.Temporary {
+				temporary++
+				assert.Equal(t, `"debug"`, entry.Value)
+			} else {
+				permanent++
+			}
+		}
+		assert.Equal(t, 2, temporary)
+		assert.Equal(t, 1, permanent)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		assert.ErrorIs(t, rtc.SetWithTTL(ctx, "log_level", "trace", time.Minute), ErrValidation)
+		assert.Error(t, rtc.SetWithTTL(ctx, "log_level", "debug", 0))
+		assert.Error(t, rtc.SetOverride(ctx, TemporaryScope, "log_level", "debug"))
+	})
+}
+
+func TestRealTimeConfig_SetWithTTLRevoke(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+	client := srv.Client
+
+	type Config struct {
+		Banner string `etcd:"banner"`
+	}
+
+	rtc, err := NewRealTimeConfig(ctx, client, "/test/config/temporary/revoke", &Config{})
+	require.NoError(t, err)
+
+	leases := func() map[clientv3.LeaseID]bool {
+		resp, err := client.Leases(ctx)
+		require.NoError(t, err)
+		ids := make(map[clientv3.LeaseID]bool, len(resp.Leases))
+		for _, l := range resp.Leases {
+			ids[l.ID] = true
+		}
+		return ids
+	}
+	before := leases()
+
+	// значение больше лимита запроса etcd: lease выдан, но транзакция не проходит
+	err = rtc.SetWithTTL(ctx, "banner", strings.Repeat("x", 2<<20), time.Hour)
+	require.Error(t, err)
+
+	for id := range leases() {
+		assert.True(t, before[id], "lease %x was not revoked", id)
+	}
+}
@@ -0,0 +1,306 @@
+package konfig
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const tenantsDir = "_tenants"
+
+// TenantScope возвращает scope значений арендатора prefix/_tenants/<id>/<key>
+func TenantScope(id string) string {
+	return Scope("tenant", id)
+}
+
+// TenantManager хранит конфиги арендаторов с общей схемой. Значения арендатора лежат
+// в prefix/_tenants/<id>/<key> и применяются поверх глобальных prefix/<key>.
+// Менеджер держит один watch на весь префикс, конфиг арендатора загружается
+// при первом обращении, а при превышении maxTenants вытесняется давно неиспользуемый.
+type TenantManager struct {
+	client     *clientv3.Client
+	prefix     string
+	opts       []Option
+	global     *RealTimeConfig
+	maxTenants int
+
+	mu      sync.Mutex
+	tenants map[string]*list.Element
+	lru     *list.List
+	// dispatched ревизия последнего события, разосланного арендаторам
+	dispatched int64
+}
+
+type tenantEntry struct {
+	id  string
+	rtc *RealTimeConfig
+}
+
+// NewTenantManager создаёт менеджер арендаторов. cfg - глобальный конфиг со значениями по умолчанию,
+// конфиги арендаторов создаются того же типа. maxTenants <= 0 отключает вытеснение.
+func NewTenantManager(ctx context.Context, cli *clientv3.Client, prefix string, cfg any, maxTenants int, opts ...Option) (*TenantManager, error) {
+	global, err := NewRealTimeConfig(ctx, cli, prefix, cfg, append(opts[:len(opts):len(opts)], WithoutWatch())...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &TenantManager{
+		client:     cli,
+		prefix:     prefix,
+		opts:       opts,
+		global:     global,
+		maxTenants: maxTenants,
+		tenants:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+
+	go m.watch(ctx)
+
+	return m, nil
+}
+
+// Global возвращает глобальный конфиг
+func (m *TenantManager) Global() *RealTimeConfig {
+	return m.global
+}
+
+// ForTenant возвращает конфиг арендатора, загружая его при первом обращении.
+// Структура конфига доступна через Config(). Set у этого конфига меняет глобальное
+// значение, значения арендатора меняются через TenantManager.Set.
+// Вытесненный конфиг перестаёт получать обновления и продолжает отдавать старые значения,
+// поэтому его нельзя хранить между обращениями: ForTenant нужно вызывать каждый раз.
+func (m *TenantManager) ForTenant(ctx context.Context, id string) (*RealTimeConfig, error) {
+	if err := validateTenant(id); err != nil {
+		return nil, err
+	}
+
+	if rtc := m.loaded(id); rtc != nil {
+		return rtc, nil
+	}
+
+	// загрузка без блокировки, чтобы не задерживать события остальных арендаторов
+	rtc, err := NewRealTimeConfig(ctx, m.client, m.prefix, m.copyGlobal(),
+		append(m.opts[:len(m.opts):len(m.opts)], withTenant(id))...)
+	if err != nil {
+		return nil, fmt.Errorf("load tenant %s: %w", id, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.tenants[id]; ok {
+		m.lru.MoveToFront(el)
+		return el.Value.(*tenantEntry).rtc, nil
+	}
+	// события, разосланные во время загрузки, арендатор не получил: догоняем их снимком
+	// на ревизии последнего события, более новые придут через watch после вставки в кэш
+	if m.dispatched > rtc.AppliedRevision() {
+		resp, err := m.client.Get(ctx, m.prefix+"/", clientv3.WithPrefix(), clientv3.WithRev(m.dispatched))
+		if err != nil {
+			return nil, fmt.Errorf("load tenant %s: %w", id, err)
+		}
+		rtc.resync(ctx, resp.Kvs)
+	}
+	rtc.markApplied(max(m.dispatched, m.global.AppliedRevision()))
+
+	m.tenants[id] = m.lru.PushFront(&tenantEntry{id: id, rtc: rtc})
+	if m.maxTenants > 0 && m.lru.Len() > m.maxTenants {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.tenants, oldest.Value.(*tenantEntry).id)
+	}
+
+	return rtc, nil
+}
+
+// Set записывает значение поля для арендатора. Инварианты проверяются для конфига арендатора,
+// который при необходимости загружается, изменение записывается в аудит.
+func (m *TenantManager) Set(ctx context.Context, id string, name ConfigName, value any) error {
+	meta, ok := m.global.schema[name]
+	if !ok {
+		return fmt.Errorf("unknown config field: %s", name)
+	}
+	if err := validateTenant(id); err != nil {
+		return err
+	}
+
+	convertedVal, err := convertType(value, meta.Type)
+	if err != nil {
+		return fmt.Errorf("type conversion failed for field %s: %w", name, err)
+	}
+	if err = checkRules(name, meta, convertedVal); err != nil {
+		return err
+	}
+	if err = m.global.authorize(ctx, name, OpSet, convertedVal); err != nil {
+		return err
+	}
+	if err = m.global.requireNoApproval(name, OpSet); err != nil {
+		return err
+	}
+
+	rtc, err := m.ForTenant(ctx, id)
+	if err != nil {
+		return err
+	}
+	c := change{name: name, field: meta, scope: TenantScope(id), value: convertedVal, present: true}
+	if err = rtc.validateChanges([]change{c}); err != nil {
+		return err
+	}
+
+	audit, err := auditRecord(ctx, OpSet)
+	if err != nil {
+		return err
+	}
+	data, err := m.global.encodeField(ctx, meta, convertedVal)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+
+	_, err = m.client.Txn(ctx).
+		Then(
+			clientv3.OpPut(m.global.tenantKey(id, name), string(data)),
+			clientv3.OpPut(m.global.tenantAuditKey(id, name), audit),
+		).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("etcd put failed: %w", err)
+	}
+
+	if rtc := m.loaded(id); rtc != nil {
+		rtc.applyChanges([]change{c})
+	}
+
+	return nil
+}
+
+// Delete удаляет значение поля арендатора, после чего действует глобальное значение
+func (m *TenantManager) Delete(ctx context.Context, id string, name ConfigName) error {
+	meta, ok := m.global.schema[name]
+	if !ok {
+		return fmt.Errorf("unknown config field: %s", name)
+	}
+	if err := validateTenant(id); err != nil {
+		return err
+	}
+	if err := m.global.authorize(ctx, name, OpSet, nil); err != nil {
+		return err
+	}
+
+	if _, err := m.client.Delete(ctx, m.global.tenantKey(id, name)); err != nil {
+		return fmt.Errorf("etcd delete failed: %w", err)
+	}
+
+	if rtc := m.loaded(id); rtc != nil {
+		rtc.applyValue(name, meta, TenantScope(id), nil, false)
+	}
+
+	return nil
+}
+
+// loaded возвращает загруженный конфиг арендатора и отмечает его как недавно использованный
+func (m *TenantManager) loaded(id string) *RealTimeConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.tenants[id]; ok {
+		m.lru.MoveToFront(el)
+		return el.Value.(*tenantEntry).rtc
+	}
+	return nil
+}
+
+// copyGlobal создаёт структуру конфига арендатора с текущими глобальными значениями
+func (m *TenantManager) copyGlobal() any {
+	m.global.mu.RLock()
+	defer m.global.mu.RUnlock()
+
+	return copyConfig(m.global.cfg, m.global.schema).Addr().Interface()
+}
+
+// watch отслеживание изменений глобальных значений и значений всех загруженных арендаторов
+func (m *TenantManager) watch(ctx context.Context) {
+	watchPrefix(ctx, m.client, m.prefix, m.global.AppliedRevision,
+		func(evs []*clientv3.Event) { m.dispatch(ctx, evs) },
+		m.markApplied,
+		m.resync)
+}
+
+// resync применяет снимок префикса к глобальному конфигу и загруженным арендаторам
+func (m *TenantManager) resync(ctx context.Context) (int64, error) {
+	resp, err := m.client.Get(ctx, m.prefix+"/", clientv3.WithPrefix())
+	if err != nil {
+		return 0, fmt.Errorf("etcd get failed: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dispatched = max(m.dispatched, resp.Header.Revision)
+	m.global.resync(ctx, resp.Kvs)
+	for el := m.lru.Front(); el != nil; el = el.Next() {
+		el.Value.(*tenantEntry).rtc.resync(ctx, resp.Kvs)
+	}
+
+	return resp.Header.Revision, nil
+}
+
+func (m *TenantManager) markApplied(revision int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.global.markApplied(revision)
+	for el := m.lru.Front(); el != nil; el = el.Next() {
+		el.Value.(*tenantEntry).rtc.markApplied(revision)
+	}
+}
+
+func (m *TenantManager) dispatch(ctx context.Context, evs []*clientv3.Event) {
+	var global []*clientv3.Event
+	own := make(map[string][]*clientv3.Event)
+	var rev int64
+	for _, ev := range evs {
+		rev = max(rev, ev.Kv.ModRevision)
+		rel := strings.TrimPrefix(string(ev.Kv.Key), m.prefix+"/")
+		if rest, ok := strings.CutPrefix(rel, tenantsDir+"/"); ok {
+			id, _, _ := strings.Cut(rest, "/")
+			own[id] = append(own[id], ev)
+			continue
+		}
+		global = append(global, ev)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dispatched = max(m.dispatched, rev)
+	if len(global) > 0 {
+		m.global.handleEvents(ctx, global)
+	}
+	for el := m.lru.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*tenantEntry)
+		tenantEvs := append(global[:len(global):len(global)], own[entry.id]...)
+		if len(tenantEvs) > 0 {
+			entry.rtc.handleEvents(ctx, tenantEvs)
+		}
+	}
+}
+
+func (rtc *RealTimeConfig) tenantKey(id string, name ConfigName) string {
+	return rtc.prefix + "/" + tenantsDir + "/" + id + "/" + string(name)
+}
+
+// tenantAuditKey ключ аудита значений арендатора prefix/_audit/changes/_tenants/<id>/<key>
+func (rtc *RealTimeConfig) tenantAuditKey(id string, name ConfigName) string {
+	return rtc.auditKey(ConfigName(tenantsDir + "/" + id + "/" + string(name)))
+}
+
+func validateTenant(id string) error {
+	if id == "" || strings.Contains(id, "/") {
+		return fmt.Errorf("invalid tenant id %q", id)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package konfig
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantManager(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+	client := srv.Client
+
+	prefix := "/test/config/tenants"
+
+	type Config struct {
+		Timeout int      `etcd:"timeout"`
+		Plans   []string `etcd:"plans"`
+		Tenants int      `etcd:"tenants"`
+	}
+
+	_, err := client.Put(ctx, prefix+"/_tenants/acme/timeout", "10")
+	require.NoError(t, err)
+
+	m, err := NewTenantManager(ctx, client, prefix, &Config{Timeout: 30, Plans: []string{"free"}}, 2)
+	require.NoError(t, err)
+
+	t.Run("Tenant values kept by sync", func(t *testing.T) {
+		resp, err := client.Get(ctx, prefix+"/_tenants/acme/timeout")
+		require.NoError(t, err)
+		assert.Len(t, resp.Kvs, 1)
+	})
+
+	t.Run("Field named tenants", func(t *testing.T) {
+		require.NoError(t, m.Global().Set(ctx, "tenants", 5))
+
+		acme, err := m.ForTenant(ctx, "acme")
+		require.NoError(t, err)
+		srv.Sync(t, acme)
+		assert.Equal(t, 5, acme.Config().(*Config).Tenants)
+	})
+
+	t.Run("Fallback to global", func(t *testing.T) {
+		acme, err := m.ForTenant(ctx, "acme")
+		require.NoError(t, err)
+		cfg := acme.Config().(*Config)
+		assert.Equal(t, 10, cfg.Timeout)
+		assert.Equal(t, []string{"free"}, cfg.Plans)
+
+		globex, err := m.ForTenant(ctx, "globex")
+		require.NoError(t, err)
+		assert.Equal(t, 30, globex.Config().(*Config).Timeout)
+
+		resolved, err := acme.Resolve(ctx, "timeout")
+		require.NoError(t, err)
+		assert.Equal(t, Resolved{Value: 10, Scope: TenantScope("acme")}, resolved)
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		acme, err := m.ForTenant(ctx, "acme")
+		require.NoError(t, err)
+		globex, err := m.ForTenant(ctx, "globex")
+		require.NoError(t, err)
+
+		require.NoError(t, m.Global().Set(ctx, "timeout", 60))
+		srv.Push(t, globex, prefix+"/_tenants/globex/plans", []string{"pro"})
+
+		timeout, err := globex.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 60, timeout)
+
+		plans, err := globex.Value("plans")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pro"}, plans)
+
+		timeout, err = acme.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 10, timeout)
+
+		plans, err = m.Global().Value("plans")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"free"}, plans)
+	})
+
+	t.Run("Set and delete", func(t *testing.T) {
+		require.NoError(t, m.Set(ctx, "acme", "timeout", 15))
+		acme, err := m.ForTenant(ctx, "acme")
+		require.NoError(t, err)
+		assert.Equal(t, 15, acme.Config().(*Config).Timeout)
+
+		require.NoError(t, m.Delete(ctx, "acme", "timeout"))
+		val, err := acme.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 60, val)
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		acme, err := m.ForTenant(ctx, "acme")
+		require.NoError(t, err)
+		_, err = m.ForTenant(ctx, "initech")
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, m.lru.Len())
+		assert.Nil(t, m.loaded("globex"))
+
+		again, err := m.ForTenant(ctx, "acme")
+		require.NoError(t, err)
+		assert.Same(t, acme, again)
+
+		globex, err := m.ForTenant(ctx, "globex")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"pro"}, globex.Config().(*Config).Plans)
+	})
+
+	t.Run("Written while loading", func(t *testing.T) {
+		// события, разосланные во время загрузки арендатора, не теряются
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 1; i <= 50; i++ {
+				_, _ = client.Put(ctx, prefix+"/_tenants/hooli/timeout", strconv.Itoa(i))
+			}
+		}()
+
+		hooli, err := m.ForTenant(ctx, "hooli")
+		require.NoError(t, err)
+		<-done
+		srv.Sync(t, hooli)
+		val, err := hooli.Value("timeout")
+		require.NoError(t, err)
+		assert.Equal(t, 50, val)
+	})
+
+	t.Run("Invalid tenant", func(t *testing.T) {
+		_, err := m.ForTenant(ctx, "")
+		assert.Error(t, err)
+		assert.Error(t, m.Set(ctx, "a/b", "timeout", 1))
+	})
+}
+
+func TestTenantManager_SetValidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/tenants/validation"
+
+	m, err := NewTenantManager(ctx, srv.Client, prefix, &poolConfig{MinPool: 1, MaxPool: 5}, 0)
+	require.NoError(t, err)
+
+	alice := WithChangeMeta(ctx, ChangeMeta{Author: "alice", Ticket: "OPS-7"})
+	require.NoError(t, m.Set(alice, "acme", "max_pool", 3))
+
+	// инвариант проверяется по значениям арендатора, а не по глобальным
+	err = m.Set(ctx, "acme", "min_pool", 4)
+	require.ErrorIs(t, err, ErrValidation)
+	require.NoError(t, m.Global().Set(ctx, "min_pool", 4))
+
+	acme, err := m.ForTenant(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, 1, acme.Config().(*poolConfig).MinPool)
+	assert.Equal(t, 3, acme.Config().(*poolConfig).MaxPool)
+
+	resp, err := srv.Client.Get(ctx, prefix+"/_audit/changes/_tenants/acme/max_pool")
+	require.NoError(t, err)
+	require.Len(t, resp.Kvs, 1)
+	var meta ChangeMeta
+	require.NoError(t, json.Unmarshal(resp.Kvs[0].Value, &meta))
+	assert.Equal(t, "alice", meta.Author)
+	assert.Equal(t, "OPS-7", meta.Ticket)
+	assert.Equal(t, OpSet, meta.Operation)
+}
@@ -0,0 +1,235 @@
+package konfig
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrValidation = errors.New("validation failed")
+)
+
+// Validator проверка инвариантов между полями конфига, например min_pool <= max_pool.
+// Если структура конфига реализует Validator, Validate вызывается на копии конфига
+// с предлагаемыми значениями до их применения в watch, SetMany, Import и Approve.
+// В watch проверка выполняется под той же блокировкой, что и применение, поэтому
+// Validate не должен обращаться к RealTimeConfig.
+type Validator interface {
+	Validate() error
+}
+
+// ValidateFunc проверка инвариантов, зарегистрированная через WithValidator.
+// cfg указатель на копию структуры конфига с предлагаемыми значениями.
+type ValidateFunc func(cfg any) error
+
+// Rule правило валидации поля из тега validate, например `validate:"min=1,max=10"`.
+// Поддерживаются required, min, max и oneof (значения через пробел).
+// Для строк, слайсов и map min/max ограничивают длину, для time.Duration допускаются "1s", "5m".
+type Rule struct {
+	Name string `json:"name"`
+	Arg  string `json:"arg,omitempty"`
+}
+
+func (r Rule) String() string {
+	if r.Arg == "" {
+		return r.Name
+	}
+	return r.Name + "=" + r.Arg
+}
+
+// parseRules разбирает тег validate и проверяет аргументы правил для типа поля
+func parseRules(tag string, t reflect.Type) ([]Rule, error) {
+	if tag == "" {
+		return nil, nil
+	}
+
+	var rules []Rule
+	for _, part := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		rule := Rule{Name: name, Arg: arg}
+
+		switch name {
+		case "required":
+		case "min", "max":
+			if _, err := ruleBound(arg, t); err != nil {
+				return nil, fmt.Errorf("rule %s: %w", rule, err)
+			}
+			if _, ok := measure(reflect.Zero(t)); !ok {
+				return nil, fmt.Errorf("rule %s is not applicable to %s", rule, t)
+			}
+		case "oneof":
+			if arg == "" {
+				return nil, fmt.Errorf("rule oneof requires values")
+			}
+		default:
+			return nil, fmt.Errorf("unknown validation rule %q", name)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// Check проверяет значение на соответствие правилу
+func (r Rule) Check(val any) error {
+	v := reflect.ValueOf(val)
+
+	switch r.Name {
+	case "required":
+		if !v.IsValid() || v.IsZero() {
+			return fmt.Errorf("value is required")
+		}
+	case "min", "max":
+		bound, err := ruleBound(r.Arg, v.Type())
+		if err != nil {
+			return err
+		}
+		m, ok := measure(v)
+		if !ok {
+			return fmt.Errorf("rule %s is not applicable to %s", r, v.Type())
+		}
+		if r.Name == "min" && m < bound {
+			return fmt.Errorf("%v is less than %s", val, r.Arg)
+		}
+		if r.Name == "max" && m > bound {
+			return fmt.Errorf("%v is greater than %s", val, r.Arg)
+		}
+	case "oneof":
+		s := fmt.Sprint(val)
+		for _, allowed := range strings.Fields(r.Arg) {
+			if s == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v is not one of [%s]", val, r.Arg)
+	}
+
+	return nil
+}
+
+// checkRules проверяет значение поля по всем его правилам. Ошибка для секрета не содержит
+// значения: она попадает в логи, события EventRejected и статус экземпляра.
+func checkRules(name ConfigName, meta fieldSchema, val any) error {
+	for _, rule := range meta.Rules {
+		err := rule.Check(val)
+		if err == nil {
+			continue
+		}
+		if meta.Secret {
+			return fmt.Errorf("%w: field %s: value violates rule %s", ErrValidation, name, rule)
+		}
+		return fmt.Errorf("%w: field %s: %v", ErrValidation, name, err)
+	}
+	return nil
+}
+
+// ruleBound разбирает аргумент min/max, для длительностей допускается запись вида "1s"
+func ruleBound(arg string, t reflect.Type) (float64, error) {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		if d, err := time.ParseDuration(arg); err == nil {
+			return float64(d), nil
+		}
+	}
+	return strconv.ParseFloat(arg, 64)
+}
+
+// measure возвращает числовое значение или длину, с которой сравниваются min/max
+func measure(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String, reflect.Slice, reflect.Map:
+		return float64(v.Len()), true
+	default:
+		return 0, false
+	}
+}
+
+// validateChanges проверяет инварианты конфига, каким он станет после применения всех changes.
+// Производные поля в проверяемой копии пересчитываются.
+func (rtc *RealTimeConfig) validateChanges(changes []change) error {
+	if !rtc.hasValidators() {
+		return nil
+	}
+
+	rtc.mu.RLock()
+	candidate := rtc.candidate(changes)
+	rtc.mu.RUnlock()
+
+	return rtc.validate(candidate.Addr().Interface())
+}
+
+func (rtc *RealTimeConfig) hasValidators() bool {
+	_, ok := rtc.cfg.(Validator)
+	return ok || len(rtc.opts.validators) > 0
+}
+
+// candidate возвращает копию конфига с применёнными changes. Вызывается под rtc.mu.
+// Значение из scope, который этот экземпляр не применяет, проверяется поверх остальных значений:
+// так его увидят экземпляры с этим scope, если остальные значения у них те же.
+func (rtc *RealTimeConfig) candidate(changes []change) reflect.Value {
+	candidate := copyConfig(rtc.cfg, rtc.schema)
+	layers := make(map[ConfigName]map[string]any)
+	foreign := make(map[ConfigName]any)
+	for _, c := range changes {
+		if !rtc.hasScope(c.scope) {
+			if c.present {
+				foreign[c.name] = c.value
+			}
+			continue
+		}
+		if layers[c.name] == nil {
+			layers[c.name] = make(map[string]any, len(rtc.layers[c.name])+1)
+			for scope, v := range rtc.layers[c.name] {
+				layers[c.name][scope] = v
+			}
+		}
+		if c.present {
+			layers[c.name][c.scope] = c.value
+		} else {
+			delete(layers[c.name], c.scope)
+		}
+	}
+	changed := make(map[ConfigName]bool, len(layers)+len(foreign))
+	for name, l := range layers {
+		for _, s := range rtc.scopes() {
+			if effective, ok := l[s]; ok {
+				setFieldValue(candidate.Field(rtc.schema[name].FieldIdx), effective)
+				break
+			}
+		}
+		changed[name] = true
+	}
+	for name, value := range foreign {
+		setFieldValue(candidate.Field(rtc.schema[name].FieldIdx), value)
+		changed[name] = true
+	}
+	rtc.derive(candidate, changed)
+
+	return candidate
+}
+
+// validate вызывает Validate конфига и валидаторы WithValidator
+func (rtc *RealTimeConfig) validate(cfg any) error {
+	if v, ok := cfg.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("%w: %w", ErrValidation, err)
+		}
+	}
+	for _, fn := range rtc.opts.validators {
+		if err := fn(cfg); err != nil {
+			return fmt.Errorf("%w: %w", ErrValidation, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package konfig
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type poolConfig struct {
+	MinPool    int    `etcd:"min_pool"`
+	MaxPool    int    `etcd:"max_pool"`
+	TLSEnabled bool   `etcd:"tls_enabled"`
+	TLSCert    string `etcd:"tls_cert"`
+}
+
+func (c *poolConfig) Validate() error {
+	if c.MinPool > c.MaxPool {
+		return errors.New("min_pool must not exceed max_pool")
+	}
+	return nil
+}
+
+func requireTLSCert(cfg any) error {
+	if c := cfg.(*poolConfig); c.TLSEnabled && c.TLSCert == "" {
+		return errors.New("tls_cert is required when tls_enabled is true")
+	}
+	return nil
+}
+
+func TestRealTimeConfig_Validator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/invariants"
+
+	cfg := &poolConfig{MinPool: 1, MaxPool: 5}
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, cfg, WithValidator(requireTLSCert))
+	require.NoError(t, err)
+
+	// второй экземпляр получает изменения только через watch
+	other := &poolConfig{MinPool: 1, MaxPool: 5}
+	rtc2, err := NewRealTimeConfig(ctx, srv.Client, prefix, other, WithValidator(requireTLSCert))
+	require.NoError(t, err)
+
+	events := rtc2.Subscribe(ctx)
+
+	err = rtc.SetMany(ctx, map[ConfigName]any{"min_pool": 10})
+	require.ErrorIs(t, err, ErrValidation)
+	assert.ErrorContains(t, err, "min_pool must not exceed max_pool")
+	assert.Equal(t, 1, cfg.MinPool)
+
+	// по отдельности оба значения нарушают инвариант, но транзакция целиком корректна
+	require.NoError(t, rtc.SetMany(ctx, map[ConfigName]any{"min_pool": 10, "max_pool": 20}))
+	srv.Sync(t, rtc2)
+	assert.Equal(t, 10, other.MinPool)
+	assert.Equal(t, 20, other.MaxPool)
+	assert.Empty(t, rtc2.Status().Rejections)
+
+	require.NoError(t, rtc.SetMany(ctx, map[ConfigName]any{"min_pool": 2, "max_pool": 4}))
+	srv.Sync(t, rtc2)
+	assert.Equal(t, 2, other.MinPool)
+	assert.Equal(t, 4, other.MaxPool)
+	assert.Empty(t, rtc2.Status().Rejections)
+
+	// запись в обход библиотеки отвергается в watch
+	srv.Push(t, rtc2, prefix+"/max_pool", 1)
+	assert.Equal(t, 4, other.MaxPool)
+	rejected := nextEvent(t, events, EventRejected)
+	assert.Equal(t, ConfigName("max_pool"), rejected.Key)
+	assert.Contains(t, rejected.Reason, "min_pool must not exceed max_pool")
+	assert.Contains(t, rtc2.Status().Rejections, "max_pool")
+
+	err = rtc.Set(ctx, "tls_enabled", true)
+	require.ErrorIs(t, err, ErrValidation)
+	assert.ErrorContains(t, err, "tls_cert is required")
+
+	broken := srv.Put(t, prefix+"/tls_enabled", true)
+	konfigtest.WaitApplied(t, rtc2, broken)
+	assert.False(t, other.TLSEnabled)
+
+	require.NoError(t, rtc.SetMany(ctx, map[ConfigName]any{"tls_enabled": true, "tls_cert": "cert.pem"}))
+	srv.Sync(t, rtc2)
+	assert.True(t, other.TLSEnabled)
+	assert.Equal(t, "cert.pem", other.TLSCert)
+
+	// откат к ревизии, где конфиг нарушал инварианты, тоже отвергается
+	err = rtc.RollbackConfig(ctx, broken)
+	require.ErrorIs(t, err, ErrValidation)
+	assert.Equal(t, "cert.pem", cfg.TLSCert)
+	assert.Equal(t, 4, cfg.MaxPool)
+
+	// переопределение проверяется и для scope, который этот экземпляр не применяет
+	err = rtc.SetOverride(ctx, Scope("region", "eu"), "max_pool", 1)
+	require.ErrorIs(t, err, ErrValidation)
+	require.NoError(t, rtc.SetOverride(ctx, Scope("region", "eu"), "max_pool", 8))
+	assert.Equal(t, 4, cfg.MaxPool)
+	require.NoError(t, rtc.DeleteOverride(ctx, Scope("region", "eu"), "max_pool"))
+
+	err = rtc.SetWithTTL(ctx, "min_pool", 10, time.Minute)
+	require.ErrorIs(t, err, ErrValidation)
+	assert.Equal(t, 2, cfg.MinPool)
+
+	// раскатка проверяется при запуске и изменении доли, продвижение - как запись базового значения
+	err = rtc.StartRollout(ctx, "min_pool", 10, 0)
+	require.ErrorIs(t, err, ErrValidation)
+	require.NoError(t, rtc.StartRollout(ctx, "min_pool", 3, 0))
+	require.NoError(t, rtc.Set(ctx, "max_pool", 2))
+	err = rtc.SetRolloutPercent(ctx, "min_pool", 100)
+	require.ErrorIs(t, err, ErrValidation)
+	_, err = rtc.PromoteRollout(ctx, "min_pool")
+	require.ErrorIs(t, err, ErrValidation)
+	assert.Equal(t, 2, cfg.MinPool)
+	_, err = rtc.GetRollout(ctx, "min_pool")
+	require.NoError(t, err)
+	require.NoError(t, rtc.AbortRollout(ctx, "min_pool"))
+}
+
+func TestRealTimeConfig_ValidatorDebounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/invariants/debounce"
+
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, &poolConfig{MinPool: 1, MaxPool: 5},
+		WithDebounce(300*time.Millisecond))
+	require.NoError(t, err)
+
+	events := rtc.Subscribe(ctx)
+
+	// min_pool=4 допустим при получении, но пока он отложен, max_pool уменьшается до 3
+	konfigtest.WaitApplied(t, rtc, srv.Put(t, prefix+"/min_pool", 4))
+	require.NoError(t, rtc.Set(ctx, "max_pool", 3))
+
+	rejected := nextEvent(t, events, EventRejected)
+	assert.Equal(t, ConfigName("min_pool"), rejected.Key)
+	assert.Contains(t, rejected.Reason, "min_pool must not exceed max_pool")
+
+	minPool, err := rtc.Value("min_pool")
+	require.NoError(t, err)
+	assert.Equal(t, 1, minPool)
+	assert.Contains(t, rtc.Status().Rejections, "min_pool")
+}
@@ -9,6 +9,7 @@ import (
 	"sort"
 	"strings"
 
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
@@ -25,17 +26,21 @@ type HistoryEntry struct {
 	CreateRev int64       `json:"create_rev"`
 	ModRev    int64       `json:"mod_rev"`
 	Version   int64       `json:"version"`
+	// Temporary значение записано через SetWithTTL и действовало ограниченное время
+	Temporary bool `json:"temporary,omitempty"`
+	// Meta сведения об изменении из аудита, если изменение прошло через библиотеку
+	Meta *ChangeMeta `json:"meta,omitempty"`
 }
 
 // GetHistory возвращает историю изменений для всех ключей
 func (rtc *RealTimeConfig) GetHistory(ctx context.Context, fromRev int64, limit int64) ([]HistoryEntry, error) {
-	return rtc.getKeyHistory(ctx, rtc.prefix, fromRev, limit)
+	return rtc.getKeyHistory(ctx, fromRev, limit, rtc.prefix)
 }
 
 // GetKeyHistory возвращает историю изменений для конкретного ключа
 func (rtc *RealTimeConfig) GetKeyHistory(ctx context.Context, key string, fromRev int64, limit int64) ([]HistoryEntry, error) {
 	fullKey := rtc.prefix + "/" + key
-	return rtc.getKeyHistory(ctx, fullKey, fromRev, limit)
+	return rtc.getKeyHistory(ctx, fromRev, limit, fullKey, rtc.temporaryLogKey(ConfigName(key)))
 }
 
 func (rtc *RealTimeConfig) parseHistoryResponse(resp *clientv3.GetResponse) ([]HistoryEntry, error) {
@@ -83,17 +88,12 @@ func (rtc *RealTimeConfig) RollbackKeyByRevision(ctx context.Context, key Config
 		return fmt.Errorf("field metadata for key %s not found", key)
 	}
 
-	var rawVal any
-	if err = json.Unmarshal(histKV.Value, &rawVal); err != nil {
-		return fmt.Errorf("failed to unmarshal value for key %s at revision %d: %w", key, revision, err)
-	}
-
-	convertedVal, err := convertType(rawVal, field.Type)
+	convertedVal, err := rtc.decodeField(ctx, field, histKV.Value)
 	if err != nil {
-		return fmt.Errorf("type conversion failed for field %s: %w", key, err)
+		return fmt.Errorf("failed to decode value for key %s at revision %d: %w", key, revision, err)
 	}
 
-	if err = rtc.Set(ctx, key, convertedVal); err != nil {
+	if err = rtc.set(ctx, key, convertedVal, OpRollback); err != nil {
 		return fmt.Errorf("rollback to revision %d failed for key %s: %w", revision, key, err)
 	}
 
@@ -135,17 +135,12 @@ func (rtc *RealTimeConfig) RollbackKeyByVersion(ctx context.Context, key ConfigN
 			continue
 		}
 
-		var rawVal any
-		if err := json.Unmarshal(histKV.Value, &rawVal); err != nil {
-			return fmt.Errorf("failed to unmarshal value for key %s: %w", key, err)
-		}
-
-		convertedVal, err := convertType(rawVal, field.Type)
+		convertedVal, err := rtc.decodeField(ctx, field, histKV.Value)
 		if err != nil {
-			return fmt.Errorf("type conversion failed for field %s: %w", key, err)
+			return fmt.Errorf("failed to decode value for key %s: %w", key, err)
 		}
 
-		if err = rtc.Set(ctx, key, convertedVal); err != nil {
+		if err = rtc.set(ctx, key, convertedVal, OpRollback); err != nil {
 			return fmt.Errorf("rollback failed for key %s: %w", key, err)
 		}
 
@@ -155,20 +150,82 @@ func (rtc *RealTimeConfig) RollbackKeyByVersion(ctx context.Context, key ConfigN
 	return fmt.Errorf("%w: version %d not found for key %s", ErrVersionNotFound, version, key)
 }
 
-func (rtc *RealTimeConfig) getKeyHistory(ctx context.Context, prefix string, fromRev int64, limit int64) ([]HistoryEntry, error) {
-	resp, err := rtc.client.Get(ctx, prefix, clientv3.WithPrefix())
+// RollbackConfig откатывает все поля конфига к состоянию на указанной ревизии одной транзакцией
+func (rtc *RealTimeConfig) RollbackConfig(ctx context.Context, revision int64) error {
+	histResp, err := rtc.client.Get(ctx, rtc.prefix+"/", clientv3.WithPrefix(), clientv3.WithRev(revision))
 	if err != nil {
-		return nil, fmt.Errorf("etcd get prefix failed: %w", err)
+		return fmt.Errorf("etcd get at revision %d failed: %w", revision, err)
 	}
-	if len(resp.Kvs) == 0 {
+
+	audit, err := auditRecord(ctx, OpRollback)
+	if err != nil {
+		return err
+	}
+
+	var ops []clientv3.Op
+	var changes []change
+	for _, kv := range histResp.Kvs {
+		name := ConfigName(strings.TrimPrefix(string(kv.Key), rtc.prefix+"/"))
+		field, ok := rtc.schema[name]
+		if !ok {
+			continue
+		}
+
+		val, err := rtc.decodeField(ctx, field, kv.Value)
+		if err != nil {
+			return fmt.Errorf("failed to decode value for key %s at revision %d: %w", name, revision, err)
+		}
+		// правила могли ужесточиться после ревизии, к которой выполняется откат
+		if err = checkRules(name, field, val); err != nil {
+			return err
+		}
+		if err = rtc.authorize(ctx, name, OpRollback, val); err != nil {
+			return err
+		}
+		if err = rtc.requireNoApproval(name, OpRollback); err != nil {
+			return err
+		}
+
+		changes = append(changes, change{name: name, field: field, scope: BaseScope, value: val, present: true})
+		ops = append(ops, clientv3.OpPut(string(kv.Key), string(kv.Value)), clientv3.OpPut(rtc.auditKey(name), audit))
+	}
+	if len(ops) == 0 {
+		return fmt.Errorf("%w: no config keys at revision %d", ErrRevisionNotFound, revision)
+	}
+	if err = rtc.validateChanges(changes); err != nil {
+		return err
+	}
+
+	if _, err = rtc.client.Txn(ctx).Then(ops...).Commit(); err != nil {
+		return fmt.Errorf("rollback to revision %d failed: %w", revision, err)
+	}
+
+	rtc.applyChanges(changes)
+
+	return nil
+}
+
+func (rtc *RealTimeConfig) getKeyHistory(ctx context.Context, fromRev int64, limit int64, prefixes ...string) ([]HistoryEntry, error) {
+	var kvs []*mvccpb.KeyValue
+	for _, prefix := range prefixes {
+		resp, err := rtc.client.Get(ctx, prefix, clientv3.WithPrefix())
+		if err != nil {
+			return nil, fmt.Errorf("etcd get prefix failed: %w", err)
+		}
+		kvs = append(kvs, resp.Kvs...)
+	}
+	if len(kvs) == 0 {
 		return nil, nil
 	}
 
 	var allEntries []HistoryEntry
 	seenEntries := make(map[string]struct{})
 
-	for _, kv := range resp.Kvs {
+	for _, kv := range kvs {
 		key := string(kv.Key)
+		if _, _, ok := rtc.historyName(key); !ok {
+			continue
+		}
 		modRev := kv.ModRevision
 		createRev := kv.CreateRevision
 
@@ -217,11 +274,36 @@ func (rtc *RealTimeConfig) getRevAsEntry(ctx context.Context, key string, rev in
 	}
 
 	kv := resp.Kvs[0]
-	return &HistoryEntry{
+	name, temporary, _ := rtc.historyName(key)
+	var value any = string(kv.Value)
+	if meta, ok := rtc.schema[name]; ok && meta.Secret {
+		value = RedactedValue
+	}
+
+	entry := &HistoryEntry{
 		Key:       string(kv.Key),
-		Value:     string(kv.Value),
+		Value:     value,
 		CreateRev: kv.CreateRevision,
 		ModRev:    kv.ModRevision,
 		Version:   kv.Version,
-	}, nil
+		Temporary: temporary,
+	}
+	if !temporary {
+		if entry.Meta, err = rtc.changeMeta(ctx, name, kv.ModRevision); err != nil {
+			return nil, err
+		}
+	}
+
+	return entry, nil
+}
+
+// historyName возвращает поле, к которому относится ключ истории. Из служебных ключей
+// в историю попадает только журнал временных значений prefix/_temporary/<key>.
+func (rtc *RealTimeConfig) historyName(key string) (ConfigName, bool, bool) {
+	rel := strings.TrimPrefix(key, rtc.prefix+"/")
+	if name, ok := strings.CutPrefix(rel, temporaryDir+"/"); ok {
+		return ConfigName(name), true, true
+	}
+
+	return ConfigName(rel), false, !isReservedName(ConfigName(rel))
 }
@@ -3,29 +3,17 @@ package konfig
 import (
 	"context"
 	"testing"
-	"time"
 
+	"github.com/olefire/realtime-config-go/konfigtest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
-const (
-	etcdEndpoint = "localhost:2379"
-	testPrefix   = "/test/config"
-)
+const testPrefix = "/test/config"
 
 func TestRealTimeConfigHistory(t *testing.T) {
-
 	ctx := context.Background()
-	client, err := clientv3.New(clientv3.Config{
-		Endpoints:   []string{etcdEndpoint},
-		DialTimeout: 5 * time.Second,
-	})
-	require.NoError(t, err)
-
-	_, err = client.Delete(ctx, testPrefix, clientv3.WithPrefix())
-	require.NoError(t, err)
+	client := konfigtest.New(t).Client
 
 	type TestConfig struct {
 		Timeout int    `etcd:"timeout"`
@@ -61,16 +49,18 @@ func TestRealTimeConfigHistory(t *testing.T) {
 			for _, entry := range history {
 				foundValues = append(foundValues, entry.Value)
 			}
-			assert.Contains(t, foundValues, 60)
-			assert.Contains(t, foundValues, "prod")
+			// значения в истории хранятся так, как записаны в etcd
+			assert.Contains(t, foundValues, "60")
+			assert.Contains(t, foundValues, `"prod"`)
 		})
 
 		t.Run("Get key history", func(t *testing.T) {
 			history, err := rtc.GetKeyHistory(ctx, "timeout", 0, 10)
 			require.NoError(t, err)
-			assert.Len(t, history, 1)
+			// fromRev=0 проходит все версии ключа: значение по умолчанию, 30 и 60
+			assert.Len(t, history, 3)
 
-			assert.Equal(t, 60, history[0].Value)
+			assert.Equal(t, "60", history[0].Value)
 		})
 
 		t.Run("Rollback to initial revision", func(t *testing.T) {
@@ -97,6 +87,4 @@ func TestRealTimeConfigHistory(t *testing.T) {
 			assert.Error(t, err)
 		})
 	})
-
-	_, _ = client.Delete(context.Background(), testPrefix, clientv3.WithPrefix())
 }
@@ -2,60 +2,342 @@ package konfig
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"reflect"
-	"strings"
+	"time"
 
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
+// watchRetryDelay пауза перед повторной подпиской после обрыва watch
+const watchRetryDelay = 100 * time.Millisecond
+
 // watch отслеживание изменений
 func (rtc *RealTimeConfig) watch(ctx context.Context) {
-	rch := rtc.client.Watch(ctx, rtc.prefix, clientv3.WithPrefix())
-
-	for wr := range rch {
-		for _, ev := range wr.Events {
-			switch ev.Type {
-			case clientv3.EventTypePut:
-				name := strings.TrimPrefix(string(ev.Kv.Key), rtc.prefix+"/")
-				field, ok := rtc.schema[ConfigName(name)]
-				if !ok {
-					continue
-				}
+	watchPrefix(ctx, rtc.client, rtc.prefix, rtc.AppliedRevision,
+		func(evs []*clientv3.Event) { rtc.handleEvents(ctx, evs) },
+		rtc.markApplied,
+		func(ctx context.Context) (int64, error) {
+			resp, err := rtc.client.Get(ctx, rtc.prefix+"/", clientv3.WithPrefix())
+			if err != nil {
+				return 0, fmt.Errorf("etcd get failed: %w", err)
+			}
+			rtc.resync(ctx, resp.Kvs)
+			return resp.Header.Revision, nil
+		})
+}
 
-				var rawVal any
-				if err := json.Unmarshal(ev.Kv.Value, &rawVal); err != nil {
-					log.Printf("Failed to unmarshal value for %s: %v", name, err)
-					continue
-				}
+// watchPrefix следит за prefix и переживает сбои потока: после обрыва переподписывается
+// со следующей после applied ревизии, а если она уже удалена компактизацией,
+// перечитывает состояние через reload и продолжает с его ревизии.
+// Повторы и старые события отсеивает handle по ревизии ключа.
+func watchPrefix(ctx context.Context, client *clientv3.Client, prefix string, applied func() int64,
+	handle func([]*clientv3.Event), mark func(int64), reload func(context.Context) (int64, error)) {
+	compacted := false
+	for ctx.Err() == nil {
+		if compacted {
+			rev, err := reload(ctx)
+			if err != nil {
+				log.Printf("Failed to resync %s after compaction: %v", prefix, err)
+			} else {
+				mark(rev)
+				compacted = false
+			}
+		}
 
-				convertedVal, err := convertType(rawVal, field.Type)
-				if err != nil {
-					log.Printf("Type conversion failed for %s: %v", name, err)
-					continue
-				}
+		if !compacted {
+			opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithPrevKV()}
+			if rev := applied(); rev > 0 {
+				opts = append(opts, clientv3.WithRev(rev+1))
+			}
 
-				fieldValue := reflect.ValueOf(rtc.cfg).Elem().Field(field.FieldIdx)
-				valToSet := reflect.ValueOf(convertedVal)
-
-				switch fieldValue.Kind() {
-				case reflect.Slice:
-					newSlice := reflect.MakeSlice(fieldValue.Type(), valToSet.Len(), valToSet.Len())
-					reflect.Copy(newSlice, valToSet)
-					fieldValue.Set(newSlice)
-				case reflect.Map:
-					newMap := reflect.MakeMap(fieldValue.Type())
-					for _, key := range valToSet.MapKeys() {
-						newMap.SetMapIndex(key, valToSet.MapIndex(key))
+			wctx, cancel := context.WithCancel(ctx)
+			for wr := range client.Watch(wctx, prefix, opts...) {
+				if errors.Is(wr.Err(), rpctypes.ErrCompacted) {
+					log.Printf("Watch on %s compacted at revision %d, resyncing", prefix, wr.CompactRevision)
+					compacted = true
+					break
+				}
+				// события одной транзакции идут подряд с одной ревизией
+				for i := 0; i < len(wr.Events); {
+					j := i + 1
+					for j < len(wr.Events) && wr.Events[j].Kv.ModRevision == wr.Events[i].Kv.ModRevision {
+						j++
 					}
-					fieldValue.Set(newMap)
-				default:
-					fieldValue.Set(valToSet)
+					handle(wr.Events[i:j])
+					i = j
 				}
+				mark(wr.Header.Revision)
+			}
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if !compacted {
+				log.Printf("Watch on %s interrupted, resuming after revision %d", prefix, applied())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+// resync применяет снимок ключей префикса, прочитанный после компактизации:
+// изменения применяются как события одной транзакции, а переопределения, которых нет в снимке, снимаются
+func (rtc *RealTimeConfig) resync(ctx context.Context, kvs []*mvccpb.KeyValue) {
+	seen := make(map[ConfigName]map[string]bool)
+	evs := make([]*clientv3.Event, 0, len(kvs))
+	for _, kv := range kvs {
+		name, scope, ok := rtc.parseKey(string(kv.Key))
+		if !ok {
+			continue
+		}
+		if seen[name] == nil {
+			seen[name] = make(map[string]bool)
+		}
+		seen[name][scope] = true
+
+		evs = append(evs, &clientv3.Event{Type: clientv3.EventTypePut, Kv: kv})
+	}
+	rtc.handleEvents(ctx, evs)
+
+	type layer struct {
+		name  ConfigName
+		scope string
+	}
+	var gone []layer
+	rtc.mu.RLock()
+	for name, layers := range rtc.layers {
+		for scope := range layers {
+			if scope != BaseScope && !seen[name][scope] {
+				gone = append(gone, layer{name, scope})
+			}
+		}
+	}
+	rtc.mu.RUnlock()
+
+	removed := make([]change, 0, len(gone))
+	for _, l := range gone {
+		removed = append(removed, change{name: l.name, field: rtc.schema[l.name], scope: l.scope})
+	}
+	rtc.applyEvents(ctx, removed)
+}
+
+// observe запоминает ревизию ключа и возвращает false для повторов и событий старше уже применённых
+func (rtc *RealTimeConfig) observe(key string, modRev int64) bool {
+	rtc.appliedMu.Lock()
+	defer rtc.appliedMu.Unlock()
+
+	if modRev <= rtc.keyRevs[key] {
+		return false
+	}
+	rtc.keyRevs[key] = modRev
+	return true
+}
+
+// AppliedRevision возвращает ревизию etcd, до которой изменения применены к cfg
+func (rtc *RealTimeConfig) AppliedRevision() int64 {
+	rtc.appliedMu.Lock()
+	defer rtc.appliedMu.Unlock()
+
+	return rtc.appliedRev
+}
+
+// settledRevision ревизия, до которой к cfg применены все изменения. В отличие от AppliedRevision
+// не включает изменения, отложенные WithDebounce или замороженные WithFlapProtection.
+func (rtc *RealTimeConfig) settledRevision() int64 {
+	applied := rtc.AppliedRevision()
+	if pending := rtc.pendingRevision(); pending > 0 && pending <= applied {
+		return pending - 1
+	}
+	return applied
+}
+
+// WaitApplied ждёт, пока изменения до ревизии revision включительно будут применены к cfg.
+// Ревизию записи возвращает etcd, например PutResponse.Header.Revision.
+func (rtc *RealTimeConfig) WaitApplied(ctx context.Context, revision int64) error {
+	for {
+		rtc.appliedMu.Lock()
+		applied, ch := rtc.appliedRev, rtc.appliedCh
+		rtc.appliedMu.Unlock()
+
+		if applied >= revision {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("revision %d not applied, applied %d: %w", revision, applied, ctx.Err())
+		case <-ch:
+		}
+	}
+}
+
+func (rtc *RealTimeConfig) markApplied(revision int64) {
+	rtc.appliedMu.Lock()
+	defer rtc.appliedMu.Unlock()
+
+	if revision <= rtc.appliedRev {
+		return
+	}
+	rtc.appliedRev = revision
+	close(rtc.appliedCh)
+	rtc.appliedCh = make(chan struct{})
+	// собственные записи статуса тоже двигают ревизию, но не должны порождать новые
+	if rtc.statusDirty {
+		rtc.statusDirty = false
+		rtc.statusChanged()
+	}
+}
+
+// change изменение ключа из watch
+type change struct {
+	key     string
+	name    ConfigName
+	field   fieldSchema
+	scope   string
+	value   any
+	present bool
+
+	// modRev ревизия изменения, prevRev ревизия предыдущего значения ключа из PrevKv
+	modRev  int64
+	prevRev int64
+}
+
+// handleEvents применяет к cfg события одной транзакции etcd с учётом ограничения частоты изменений.
+// Если конфиг с новыми значениями нарушает инварианты Validator и WithValidator,
+// транзакция отвергается целиком.
+func (rtc *RealTimeConfig) handleEvents(ctx context.Context, evs []*clientv3.Event) {
+	changes := make([]change, 0, len(evs))
+	for _, ev := range evs {
+		if c, ok := rtc.decodeEvent(ctx, ev); ok {
+			changes = append(changes, c)
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	if err := rtc.validateChanges(changes); err != nil {
+		log.Printf("Rejected update at revision %d: %v", changes[0].modRev, err)
+		for _, c := range changes {
+			rtc.reject(c.key, c, err)
+		}
+		return
+	}
+
+	for _, c := range changes {
+		rtc.accept(c.key)
+	}
+	if rtc.opts.debounce <= 0 && rtc.opts.flapChanges <= 0 {
+		rtc.applyEvents(ctx, changes)
+		return
+	}
+	rtc.limitEvents(ctx, changes)
+}
+
+// decodeEvent разбирает событие watch в изменение поля. Значения, которые не удалось
+// декодировать или которые нарушают правила поля, отвергаются.
+func (rtc *RealTimeConfig) decodeEvent(ctx context.Context, ev *clientv3.Event) (change, bool) {
+	key := string(ev.Kv.Key)
+	name, scope, ok := rtc.parseKey(key)
+	if !ok || !rtc.observe(key, ev.Kv.ModRevision) {
+		return change{}, false
+	}
+	rtc.touchStatus()
 
-				log.Printf("Config updated: %s = %v", name, convertedVal)
+	c := change{key: key, name: name, field: rtc.schema[name], scope: scope, modRev: ev.Kv.ModRevision}
+	if ev.PrevKv != nil {
+		c.prevRev = ev.PrevKv.ModRevision
+	}
+
+	switch ev.Type {
+	case clientv3.EventTypePut:
+		data := ev.Kv.Value
+		if scope == RolloutScope {
+			var inCohort bool
+			var err error
+			if data, inCohort, err = rtc.rolloutValue(name, data); err != nil {
+				log.Printf("Failed to decode rollout for %s: %v", name, err)
+				rtc.reject(key, c, err)
+				return change{}, false
+			}
+			if !inCohort {
+				return c, true
 			}
 		}
+
+		convertedVal, err := rtc.decodeField(ctx, c.field, data)
+		if err != nil {
+			log.Printf("Failed to decode value for %s: %v", name, err)
+			rtc.reject(key, c, err)
+			return change{}, false
+		}
+
+		if err = checkRules(name, c.field, convertedVal); err != nil {
+			log.Printf("Rejected value for %s: %v", name, err)
+			rtc.reject(key, c, err)
+			return change{}, false
+		}
+
+		c.value, c.present = convertedVal, true
+		return c, true
+	case clientv3.EventTypeDelete:
+		// удаление базового ключа не сбрасывает значение, а удаление переопределения
+		// возвращает поле к значению следующего по приоритету scope
+		if scope == BaseScope {
+			rtc.accept(key)
+			return change{}, false
+		}
+		return c, true
 	}
+
+	return change{}, false
+}
+
+// applyEvents проверяет и применяет изменения одной транзакции из watch разом, см. applyValidChanges.
+// Отложенные изменения могли стать недопустимыми, пока ждали применения, тогда транзакция
+// отвергается целиком и applyEvents возвращает false.
+func (rtc *RealTimeConfig) applyEvents(ctx context.Context, changes []change) bool {
+	if len(changes) == 0 {
+		return true
+	}
+
+	results, err := rtc.applyValidChanges(changes)
+	if err != nil {
+		log.Printf("Rejected update at revision %d: %v", changes[len(changes)-1].modRev, err)
+		for _, c := range changes {
+			rtc.reject(c.key, c, err)
+		}
+		return false
+	}
+	for i, c := range changes {
+		// значение может не измениться, например при перешифровании секрета новым ключом
+		r := results[i]
+		if !r.changed {
+			continue
+		}
+
+		switch r.from {
+		case BaseScope:
+			log.Printf("Config updated: %s = %v", c.name, redact(c.field, r.effective))
+		case RolloutScope:
+			log.Printf("Config updated: %s = %v (rollout)", c.name, redact(c.field, r.effective))
+		default:
+			log.Printf("Config updated: %s = %v (override %s)", c.name, redact(c.field, r.effective), r.from)
+		}
+
+		// глобальные ключи арендаторов TenantManager наблюдает глобальный конфиг
+		if c.scope == BaseScope && c.present && rtc.opts.tenant == "" {
+			rtc.watchHealth(ctx, c)
+		}
+	}
+
+	return true
 }
@@ -2,36 +2,29 @@ package konfig
 
 import (
 	"context"
-	"encoding/json"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/olefire/realtime-config-go/konfigtest"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 func TestWatch(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	client, err := clientv3.New(clientv3.Config{
-		Endpoints:   []string{"localhost:2379"},
-		DialTimeout: 2 * time.Second,
-	})
-	require.NoError(t, err)
-	defer client.Close()
+	srv := konfigtest.New(t)
 
 	prefix := "/test/config/watch"
-	_, err = client.Delete(ctx, prefix, clientv3.WithPrefix())
-	require.NoError(t, err)
 
 	type TestConfig struct {
 		Value int `etcd:"value"`
 	}
 
 	cfg := &TestConfig{}
-	rtc, err := NewRealTimeConfig(ctx, client, prefix, cfg)
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, cfg, WithoutWatch())
 	require.NoError(t, err)
 
 	var wg sync.WaitGroup
@@ -42,21 +35,11 @@ func TestWatch(t *testing.T) {
 		rtc.watch(ctx)
 	}()
 
-	time.Sleep(100 * time.Millisecond)
+	srv.Push(t, rtc, prefix+"/value", 42)
+	assert.Equal(t, 42, cfg.Value, "Config value should be updated")
 
-	_, err = client.Put(ctx, prefix+"/value", "42")
-	require.NoError(t, err)
-
-	require.Eventually(t, func() bool {
-		return cfg.Value == 42
-	}, time.Second, 100*time.Millisecond, "Config value should be updated")
-
-	_, err = client.Put(ctx, prefix+"/value", "100")
-	require.NoError(t, err)
-
-	require.Eventually(t, func() bool {
-		return cfg.Value == 100
-	}, time.Second, 100*time.Millisecond, "Config value should be updated again")
+	srv.Push(t, rtc, prefix+"/value", 100)
+	assert.Equal(t, 100, cfg.Value, "Config value should be updated again")
 
 	cancel()
 
@@ -76,34 +59,107 @@ func TestWatch(t *testing.T) {
 func TestWatchWithMap(t *testing.T) {
 	ctx := context.Background()
 
-	client, err := clientv3.New(clientv3.Config{
-		Endpoints:   []string{"localhost:2379"},
-		DialTimeout: 2 * time.Second,
-	})
-	require.NoError(t, err)
-	defer client.Close()
+	srv := konfigtest.New(t)
 
 	prefix := "/test/config/watch_map"
-	_, err = client.Delete(ctx, prefix, clientv3.WithPrefix())
-	require.NoError(t, err)
 
 	type TestConfig struct {
 		Settings map[string]int `etcd:"settings"`
 	}
 
 	cfg := &TestConfig{}
-	rtc, err := NewRealTimeConfig(ctx, client, prefix, cfg)
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, cfg)
 	require.NoError(t, err)
 
-	go rtc.watch(ctx)
-	time.Sleep(100 * time.Millisecond)
+	srv.Push(t, rtc, prefix+"/settings", map[string]int{"timeout": 30, "retries": 3})
+	assert.Equal(t, map[string]int{"timeout": 30, "retries": 3}, cfg.Settings)
+}
+
+func TestWatchMissedBeforeSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+
+	prefix := "/test/config/watch_missed"
 
-	jsonValue, err := json.Marshal(map[string]int{"timeout": 30, "retries": 3})
+	type TestConfig struct {
+		Value int `etcd:"value"`
+	}
+
+	cfg := &TestConfig{}
+	rtc, err := NewRealTimeConfig(ctx, srv.Client, prefix, cfg, WithoutWatch())
 	require.NoError(t, err)
-	_, err = client.Put(ctx, prefix+"/settings", string(jsonValue))
+
+	rev := srv.Put(t, prefix+"/value", 7)
+	go rtc.watch(ctx)
+
+	konfigtest.WaitApplied(t, rtc, rev)
+	assert.Equal(t, 7, cfg.Value)
+}
+
+func TestWatch_Faults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv := konfigtest.New(t)
+	f := srv.Faults(t)
+
+	prefix := "/test/config/watch_faults"
+
+	type TestConfig struct {
+		Value int    `etcd:"value"`
+		Mode  string `etcd:"mode"`
+	}
+
+	cfg := &TestConfig{Mode: "prod"}
+	rtc, err := NewRealTimeConfig(ctx, f.Client, prefix, cfg, WithOverrideScopes(Scope("region", "eu")))
 	require.NoError(t, err)
 
-	require.Eventually(t, func() bool {
-		return cfg.Settings != nil && cfg.Settings["timeout"] == 30
-	}, time.Second, 100*time.Millisecond)
+	t.Run("Dropped stream", func(t *testing.T) {
+		f.DropWatches()
+		rev := srv.Put(t, prefix+"/value", 1)
+
+		konfigtest.WaitApplied(t, rtc, rev)
+		assert.Equal(t, 1, cfg.Value)
+	})
+
+	t.Run("Out of order and duplicates", func(t *testing.T) {
+		f.SetDelay(time.Hour)
+		srv.Put(t, prefix+"/value", 2)
+		srv.Put(t, prefix+"/value", 3)
+		rev := srv.Put(t, prefix+"/value", 4)
+
+		// события придут повторно после переподписки, одним ответом в обратном порядке
+		f.SetReorder(true)
+		f.SetDuplicate(true)
+		f.DropWatches()
+		f.SetDelay(0)
+		defer f.SetReorder(false)
+		defer f.SetDuplicate(false)
+
+		konfigtest.WaitApplied(t, rtc, rev)
+		assert.Equal(t, 4, cfg.Value)
+
+		srv.Push(t, rtc, prefix+"/value", 5)
+		assert.Equal(t, 5, cfg.Value)
+	})
+
+	t.Run("Compaction", func(t *testing.T) {
+		srv.Push(t, rtc, prefix+"/_overrides/region=eu/mode", "canary")
+		assert.Equal(t, "canary", cfg.Mode)
+
+		f.SetDelay(time.Hour)
+		srv.Put(t, prefix+"/value", 6)
+		srv.Delete(t, prefix+"/_overrides/region=eu/mode")
+		rev := f.Compact(t)
+		f.SetDelay(0)
+
+		konfigtest.WaitApplied(t, rtc, rev)
+		assert.Equal(t, 6, cfg.Value)
+		assert.Equal(t, "prod", cfg.Mode)
+
+		srv.Push(t, rtc, prefix+"/value", 7)
+		assert.Equal(t, 7, cfg.Value)
+	})
 }